@@ -0,0 +1,49 @@
+package s3provider
+
+import (
+	"math"
+	"time"
+)
+
+// The time functions the provider waits with so that polling can be tested without real sleeps
+type Clock interface {
+	// Returns a channel that receives the current time after d has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Determines how long to wait between polls
+type pollSchedule struct {
+	// The normal time between polls
+	interval time.Duration
+	// Up to this much random time is added to each normal interval
+	jitter time.Duration
+	// The wait after the first failed poll, before jitter. Retry backoff is disabled if 0
+	retryInitial time.Duration
+	// The longest wait between failed polls, before jitter
+	retryMax time.Duration
+	// How much the wait grows with each consecutive failure
+	retryMultiplier float64
+	// Returns a random number in [0.0,1.0)
+	random func() float64
+}
+
+// Returns how long to wait before the next poll given the number of polls in a row that have failed
+func (schedule pollSchedule) next(failures int) time.Duration {
+	if failures > 0 && schedule.retryInitial > 0 {
+		delay := math.Min(float64(schedule.retryInitial)*math.Pow(schedule.retryMultiplier, float64(failures-1)), float64(schedule.retryMax))
+		// Wait a random time between half the delay and the delay so that replicas that failed together
+		// do not all retry at the same moment
+		return time.Duration(delay/2 + schedule.random()*delay/2)
+	}
+
+	if schedule.jitter <= 0 {
+		return schedule.interval
+	}
+	return schedule.interval + time.Duration(schedule.random()*float64(schedule.jitter))
+}
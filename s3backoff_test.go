@@ -0,0 +1,72 @@
+package s3provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollScheduleNoJitter(t *testing.T) {
+	schedule := pollSchedule{
+		interval: time.Minute,
+	}
+
+	assert.Equal(t, time.Minute, schedule.next(0))
+	// Without retry backoff failures poll at the normal interval
+	assert.Equal(t, time.Minute, schedule.next(3))
+}
+
+func TestPollScheduleJitter(t *testing.T) {
+	var tests = []struct {
+		name     string
+		random   float64
+		expected time.Duration
+	}{
+		{"none", 0, time.Minute},
+		{"half", 0.5, time.Minute + 5*time.Second},
+		{"most", 0.9, time.Minute + 9*time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := pollSchedule{
+				interval: time.Minute,
+				jitter:   10 * time.Second,
+				random:   func() float64 { return tt.random },
+			}
+			assert.Equal(t, tt.expected, schedule.next(0))
+		})
+	}
+}
+
+func TestPollScheduleBackoff(t *testing.T) {
+	var tests = []struct {
+		name     string
+		random   float64
+		expected []time.Duration
+	}{
+		// Half of the delay is always waited
+		{"least", 0, []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond, 4500 * time.Millisecond, 13500 * time.Millisecond, 15 * time.Second, 15 * time.Second}},
+		{"half", 0.5, []time.Duration{750 * time.Millisecond, 2250 * time.Millisecond, 6750 * time.Millisecond, 20250 * time.Millisecond, 22500 * time.Millisecond, 22500 * time.Millisecond}},
+		{"most", 0.9, []time.Duration{950 * time.Millisecond, 2850 * time.Millisecond, 8550 * time.Millisecond, 25650 * time.Millisecond, 28500 * time.Millisecond, 28500 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := pollSchedule{
+				interval:        time.Minute,
+				jitter:          10 * time.Second,
+				retryInitial:    time.Second,
+				retryMax:        30 * time.Second,
+				retryMultiplier: 3,
+				random:          func() float64 { return tt.random },
+			}
+
+			assert.Equal(t, time.Minute+time.Duration(tt.random*float64(10*time.Second)), schedule.next(0))
+			for i, failures := range []int{1, 2, 3, 4, 5, 50} {
+				assert.Equal(t, tt.expected[i], schedule.next(failures), "%d failures", failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package s3provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What is written to disk for every successfully merged configuration
+type cachedConfiguration struct {
+	// The ETag of each object ("bucket/key") that the configuration was merged from
+	ETags map[string]string `json:"etags"`
+	// The merged configuration
+	Configuration json.RawMessage `json:"configuration"`
+}
+
+// Persists the last merged configuration so that traefik can start with it if the object store is unreachable
+type configCache struct {
+	// The file that the configuration is stored in
+	path string
+}
+
+// Creates a cache for the provider name in dir, creating dir if needed
+func newConfigCache(dir string, name string) (*configCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory %s: %w", dir, err)
+	}
+	fileName := strings.NewReplacer("/", "_", "\\", "_").Replace(name) + ".json"
+	return &configCache{
+		path: filepath.Join(dir, fileName),
+	}, nil
+}
+
+// Atomically replaces the cached configuration by writing a temp file and renaming it over the cache file
+func (cache *configCache) Save(configuration []byte, etags map[string]string) error {
+	raw, err := json.Marshal(cachedConfiguration{
+		ETags:         etags,
+		Configuration: configuration,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cache.path), filepath.Base(cache.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Cleans up the temp file on failure. After the rename this is a no-op
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cache.path)
+}
+
+// Returns the cached configuration or nil if nothing has been cached yet
+func (cache *configCache) Load() (*cachedConfiguration, error) {
+	raw, err := os.ReadFile(cache.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var cached cachedConfiguration
+	if err := json.Unmarshal(raw, &cached); err != nil {
+		return nil, fmt.Errorf("unable to parse cached configuration %s: %w", cache.path, err)
+	}
+	return &cached, nil
+}
@@ -0,0 +1,56 @@
+package s3provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheLoadEmpty(t *testing.T) {
+	cache, err := newConfigCache(t.TempDir(), "test")
+	require.NoError(t, err)
+
+	cached, err := cache.Load()
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+func TestCacheSaveAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "cache")
+	cache, err := newConfigCache(dir, "test")
+	require.NoError(t, err)
+
+	require.NoError(t, cache.Save([]byte(`{"http":{}}`), map[string]string{"bucket/a.yaml": `"etag1"`}))
+	require.NoError(t, cache.Save([]byte(`{"tls":{}}`), map[string]string{"bucket/a.yaml": `"etag2"`}))
+
+	cached, err := cache.Load()
+	require.NoError(t, err)
+	assert.Equal(t, `{"tls":{}}`, string(cached.Configuration))
+	assert.Equal(t, map[string]string{"bucket/a.yaml": `"etag2"`}, cached.ETags)
+
+	// Only the cache file is left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "test.json", entries[0].Name())
+}
+
+func TestCacheNameIsSanitized(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newConfigCache(dir, "s3/provider")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "s3_provider.json"), cache.path)
+}
+
+func TestCacheLoadCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newConfigCache(dir, "test")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cache.path, []byte("{"), 0o600))
+
+	_, err = cache.Load()
+	require.ErrorContains(t, err, "unable to parse cached configuration")
+}
@@ -2,26 +2,205 @@ package s3provider
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// Options that change how the s3 client is built on top of the default aws config.
+// Any unset value falls back to the aws sdk's normal environment/shared config resolution.
+type ClientConfig struct {
+	// The base endpoint url of an s3 compatible object store (i.e. https://us-ord-1.linodeobjects.com)
+	Endpoint string `json:"endpoint,omitempty"`
+	// The region of the bucket
+	Region string `json:"region,omitempty"`
+	// Address buckets as <endpoint>/<bucket> instead of <bucket>.<endpoint> (required by most MinIO setups)
+	UsePathStyle bool `json:"usePathStyle,omitempty"`
+	// The shared config profile to use
+	Profile string `json:"profile,omitempty"`
+	// A shared credentials file that is re-read whenever it is modified
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// A static access key id
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	// A file holding the access key id (i.e. a docker or kubernetes secret), re-read when modified
+	AccessKeyIDFile string `json:"accessKeyIdFile,omitempty"`
+	// A static secret access key
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	// A file holding the secret access key (i.e. a docker or kubernetes secret), re-read when modified
+	SecretAccessKeyFile string `json:"secretAccessKeyFile,omitempty"`
+	// An optional session token to go with static credentials
+	SessionToken string `json:"sessionToken,omitempty"`
+	// A role to assume with the credentials above. The session is renewed before it expires
+	RoleArn string `json:"roleArn,omitempty"`
+	// The external id required by the role's trust policy, if any
+	ExternalID string `json:"externalId,omitempty"`
+	// The name of the assumed role session. Defaults to traefik-s3provider
+	SessionName string `json:"sessionName,omitempty"`
+	// A Golang duration string for how long each assumed role session lasts. Defaults to the sts default of 1h
+	Duration string `json:"duration,omitempty"`
+}
+
+// The range of session durations that sts AssumeRole accepts
+const (
+	minAssumeRoleDuration = 15 * time.Minute
+	maxAssumeRoleDuration = 12 * time.Hour
+)
+
+// Whether or not any explicit access key settings were provided
+func (clientConfig ClientConfig) hasStaticCredentials() bool {
+	return clientConfig.AccessKeyID != "" || clientConfig.AccessKeyIDFile != "" ||
+		clientConfig.SecretAccessKey != "" || clientConfig.SecretAccessKeyFile != "" ||
+		clientConfig.SessionToken != ""
+}
+
+// Checks that the credentials options do not conflict with each other
+func (clientConfig ClientConfig) Validate() error {
+	if clientConfig.AccessKeyID != "" && clientConfig.AccessKeyIDFile != "" {
+		return errors.New("only one of accessKeyId or accessKeyIdFile can be set")
+	}
+	if clientConfig.SecretAccessKey != "" && clientConfig.SecretAccessKeyFile != "" {
+		return errors.New("only one of secretAccessKey or secretAccessKeyFile can be set")
+	}
+	if err := clientConfig.validateAssumeRole(); err != nil {
+		return err
+	}
+	if !clientConfig.hasStaticCredentials() {
+		return nil
+	}
+	if clientConfig.CredentialsFile != "" {
+		return errors.New("credentialsFile cannot be combined with static credentials")
+	}
+	if clientConfig.AccessKeyID == "" && clientConfig.AccessKeyIDFile == "" {
+		return errors.New("static credentials require accessKeyId or accessKeyIdFile")
+	}
+	if clientConfig.SecretAccessKey == "" && clientConfig.SecretAccessKeyFile == "" {
+		return errors.New("static credentials require secretAccessKey or secretAccessKeyFile")
+	}
+	return nil
+}
+
+// Checks that the assume role options are only set with a role and that the duration is one sts accepts
+func (clientConfig ClientConfig) validateAssumeRole() error {
+	if clientConfig.RoleArn == "" && (clientConfig.ExternalID != "" || clientConfig.SessionName != "" || clientConfig.Duration != "") {
+		return errors.New("externalId, sessionName and duration require a roleArn")
+	}
+	if clientConfig.Duration == "" {
+		return nil
+	}
+	duration, err := time.ParseDuration(clientConfig.Duration)
+	if err != nil {
+		return fmt.Errorf("invalid assume role duration: %w", err)
+	}
+	if duration < minAssumeRoleDuration || duration > maxAssumeRoleDuration {
+		return fmt.Errorf("invalid assume role duration %s: sts allows between %s and %s", clientConfig.Duration, minAssumeRoleDuration, maxAssumeRoleDuration)
+	}
+	return nil
+}
+
 // Do this once and continue to fail since it is something you would more than likely need to rebuild
 // on the machine
-func NewS3Client() (*s3.Client, error) {
-	// Get the client defaults and then wrap the provider if we want to use refreshable credentials file
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func NewS3Client(ctx context.Context, clientConfig ClientConfig) (*s3.Client, error) {
+	cfg, err := loadAwsConfig(ctx, clientConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO - do a refesh from file for credentials
-	// wrap credentials and if "check file for refresh", then perform a stat check on the modified
-	// if the file is modified perform a singleflight check
-
 	// Create an S3 client
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(cfg, func(opts *s3.Options) {
+		if clientConfig.Endpoint != "" {
+			opts.BaseEndpoint = aws.String(clientConfig.Endpoint)
+		}
+		opts.UsePathStyle = clientConfig.UsePathStyle
+	})
+
+	return client, nil
+}
+
+// Loads the region and credentials of the connection for any aws service client
+func loadAwsConfig(ctx context.Context, clientConfig ClientConfig) (aws.Config, error) {
+	if err := clientConfig.Validate(); err != nil {
+		return aws.Config{}, err
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if clientConfig.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(clientConfig.Region))
+	}
+	if clientConfig.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(clientConfig.Profile))
+	}
+	if clientConfig.CredentialsFile != "" {
+		loadOpts = append(loadOpts, config.WithSharedCredentialsFiles([]string{clientConfig.CredentialsFile}))
+	}
+
+	// Get the client defaults and then wrap the provider if we want to use refreshable credentials file
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if clientConfig.CredentialsFile != "" {
+		profile := clientConfig.Profile
+		if profile == "" {
+			profile = profileFromEnv()
+		}
+		cfg.Credentials = NewFileCredentialsProvider(clientConfig.CredentialsFile, profile)
+	} else if clientConfig.hasStaticCredentials() {
+		cfg.Credentials = NewStaticCredentialsProvider(
+			clientConfig.AccessKeyID,
+			clientConfig.AccessKeyIDFile,
+			clientConfig.SecretAccessKey,
+			clientConfig.SecretAccessKeyFile,
+			clientConfig.SessionToken,
+		)
+	}
+
+	if clientConfig.RoleArn != "" {
+		var duration time.Duration
+		if clientConfig.Duration != "" {
+			// Already checked by Validate
+			duration, _ = time.ParseDuration(clientConfig.Duration)
+		}
+		cfg.Credentials = NewAssumeRoleProvider(cfg, clientConfig.RoleArn, clientConfig.ExternalID, clientConfig.SessionName, duration)
+	}
+
+	return cfg, nil
+}
+
+// The profile the aws sdk would use if none were configured
+func profileFromEnv() string {
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// Builds s3 clients on demand and reuses them for any connection with identical settings
+type clientCache struct {
+	clients map[ClientConfig]*s3.Client
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{
+		clients: make(map[ClientConfig]*s3.Client),
+	}
+}
+
+func (cache *clientCache) get(ctx context.Context, clientConfig ClientConfig) (*s3.Client, error) {
+	if client, ok := cache.clients[clientConfig]; ok {
+		return client, nil
+	}
+
+	client, err := NewS3Client(ctx, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	cache.clients[clientConfig] = client
 
 	return client, nil
-}
\ No newline at end of file
+}
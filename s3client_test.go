@@ -0,0 +1,198 @@
+package s3provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>assumedKey%d</AccessKeyId>
+      <SecretAccessKey>assumedSecret</SecretAccessKey>
+      <SessionToken>assumedToken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/config-reader/traefik</Arn>
+      <AssumedRoleId>AROA123:traefik</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata>
+    <RequestId>c6104cbe-af31-11e0-8154-cbc7ccf896c7</RequestId>
+  </ResponseMetadata>
+</AssumeRoleResponse>`
+
+// A stand in sts endpoint that records AssumeRole requests
+type stubSts struct {
+	mu sync.Mutex
+	// The form of each AssumeRole request
+	requests []url.Values
+	// How long from now each returned session is valid
+	sessionLength time.Duration
+}
+
+func newStubSts(t *testing.T, sessionLength time.Duration) *stubSts {
+	t.Helper()
+	stub := &stubSts{sessionLength: sessionLength}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, r.PostForm)
+		count := len(stub.requests)
+		stub.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, assumeRoleResponse, count, time.Now().Add(sessionLength).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_ENDPOINT_URL_STS", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	return stub
+}
+
+func (stub *stubSts) calls() []url.Values {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return append([]url.Values{}, stub.requests...)
+}
+
+func TestNewS3ClientAssumeRole(t *testing.T) {
+	ctx := context.Background()
+	stub := newStubSts(t, time.Hour)
+
+	client, err := NewS3Client(ctx, ClientConfig{
+		AccessKeyID:     "baseKey",
+		SecretAccessKey: "baseSecret",
+		RoleArn:         "arn:aws:iam::123456789012:role/config-reader",
+		ExternalID:      "external",
+		SessionName:     "traefik",
+		Duration:        "30m",
+	})
+	require.NoError(t, err)
+
+	creds, err := client.Options().Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "assumedKey1", creds.AccessKeyID)
+	assert.Equal(t, "assumedSecret", creds.SecretAccessKey)
+	assert.Equal(t, "assumedToken", creds.SessionToken)
+
+	calls := stub.calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, "AssumeRole", calls[0].Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/config-reader", calls[0].Get("RoleArn"))
+	assert.Equal(t, "external", calls[0].Get("ExternalId"))
+	assert.Equal(t, "traefik", calls[0].Get("RoleSessionName"))
+	assert.Equal(t, "1800", calls[0].Get("DurationSeconds"))
+}
+
+func TestNewS3ClientAssumeRoleDefaults(t *testing.T) {
+	ctx := context.Background()
+	stub := newStubSts(t, time.Hour)
+
+	client, err := NewS3Client(ctx, ClientConfig{
+		AccessKeyID:     "baseKey",
+		SecretAccessKey: "baseSecret",
+		RoleArn:         "arn:aws:iam::123456789012:role/config-reader",
+	})
+	require.NoError(t, err)
+
+	_, err = client.Options().Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+
+	calls := stub.calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, "traefik-s3provider", calls[0].Get("RoleSessionName"))
+	assert.Equal(t, "", calls[0].Get("ExternalId"))
+}
+
+func TestNewS3ClientAssumeRoleCached(t *testing.T) {
+	ctx := context.Background()
+	stub := newStubSts(t, time.Hour)
+
+	client, err := NewS3Client(ctx, ClientConfig{
+		AccessKeyID:     "baseKey",
+		SecretAccessKey: "baseSecret",
+		RoleArn:         "arn:aws:iam::123456789012:role/config-reader",
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		creds, err := client.Options().Credentials.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "assumedKey1", creds.AccessKeyID)
+	}
+	assert.Len(t, stub.calls(), 1)
+}
+
+func TestNewS3ClientAssumeRoleRenewsEarly(t *testing.T) {
+	ctx := context.Background()
+	// Sessions that are already inside of the renewal window
+	stub := newStubSts(t, assumeRoleExpiryWindow/4)
+
+	client, err := NewS3Client(ctx, ClientConfig{
+		AccessKeyID:     "baseKey",
+		SecretAccessKey: "baseSecret",
+		RoleArn:         "arn:aws:iam::123456789012:role/config-reader",
+	})
+	require.NoError(t, err)
+
+	creds, err := client.Options().Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "assumedKey1", creds.AccessKeyID)
+
+	creds, err = client.Options().Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "assumedKey2", creds.AccessKeyID)
+	assert.Len(t, stub.calls(), 2)
+}
+
+func TestClientConfigAssumeRoleValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		config        ClientConfig
+		expectedError string
+	}{
+		{"external id without role", ClientConfig{ExternalID: "ext"}, "externalId, sessionName and duration require a roleArn"},
+		{"duration without role", ClientConfig{Duration: "1h"}, "externalId, sessionName and duration require a roleArn"},
+		{"bad duration", ClientConfig{RoleArn: "arn", Duration: "1hour"}, "invalid assume role duration"},
+		{"negative duration", ClientConfig{RoleArn: "arn", Duration: "-1h"}, "invalid assume role duration -1h: sts allows between 15m0s and 12h0m0s"},
+		{"zero duration", ClientConfig{RoleArn: "arn", Duration: "0s"}, "invalid assume role duration 0s"},
+		{"duration too short", ClientConfig{RoleArn: "arn", Duration: "10m"}, "invalid assume role duration 10m"},
+		{"duration too long", ClientConfig{RoleArn: "arn", Duration: "13h"}, "invalid assume role duration 13h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewS3Client(context.Background(), tt.config)
+			require.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestNewS3ClientEndpoint(t *testing.T) {
+	client, err := NewS3Client(context.Background(), ClientConfig{
+		Endpoint:     "http://minio.local:9000",
+		Region:       "us-east-1",
+		UsePathStyle: true,
+	})
+	require.NoError(t, err)
+
+	opts := client.Options()
+	assert.Equal(t, "http://minio.local:9000", *opts.BaseEndpoint)
+	assert.Equal(t, "us-east-1", opts.Region)
+	assert.True(t, opts.UsePathStyle)
+}
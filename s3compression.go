@@ -0,0 +1,103 @@
+package s3provider
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The most bytes that a compressed object can decompress to if not configured
+const defaultMaxDecompressedSize int64 = 64 << 20
+
+// How an object's contents are compressed
+type Compression uint8
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+// Determines the compression of an object from its Content-Encoding or, if that does not name
+// a supported compression, from the key's extension
+func detectCompression(contentEncoding string, key string) Compression {
+	for _, encoding := range strings.Split(contentEncoding, ",") {
+		switch strings.TrimSpace(strings.ToLower(encoding)) {
+		case "gzip", "x-gzip":
+			return Gzip
+		case "zstd":
+			return Zstd
+		}
+	}
+	return compressionFromKey(key)
+}
+
+// Determines the compression of a key from its ".gz" or ".zst" extension
+func compressionFromKey(key string) Compression {
+	switch filepath.Ext(key) {
+	case ".gz":
+		return Gzip
+	case ".zst":
+		return Zstd
+	default:
+		return Uncompressed
+	}
+}
+
+// Returns a reader that decompresses body as it is read. Reading more than maxSize decompressed
+// bytes fails so that a small object cannot expand without bound
+func decompress(body io.Reader, compression Compression, maxSize int64) (io.ReadCloser, error) {
+	var decompressed io.ReadCloser
+	switch compression {
+	case Gzip:
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		decompressed = reader
+	case Zstd:
+		// The window a frame asks for is allocated up front, so cap it, and the decoder's memory, at maxSize
+		// rather than letting a crafted frame allocate far more than the object may decompress to
+		window := uint64(maxSize)
+		if window < zstd.MinWindowSize {
+			window = zstd.MinWindowSize
+		}
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(maxSize)), zstd.WithDecoderMaxWindow(window))
+		if err != nil {
+			return nil, err
+		}
+		decompressed = decoder.IOReadCloser()
+	default:
+		return io.NopCloser(body), nil
+	}
+	return &maxSizeReader{ReadCloser: decompressed, remaining: maxSize, maxSize: maxSize}, nil
+}
+
+// Fails reads once more than maxSize bytes have been read
+type maxSizeReader struct {
+	io.ReadCloser
+	// How many more bytes can be read
+	remaining int64
+	maxSize   int64
+}
+
+func (reader *maxSizeReader) Read(p []byte) (int, error) {
+	if reader.remaining < 0 {
+		return 0, fmt.Errorf("decompressed object is larger than %d bytes", reader.maxSize)
+	}
+	// Read one byte past the limit so that an object of exactly maxSize bytes is allowed
+	if int64(len(p)) > reader.remaining+1 {
+		p = p[:reader.remaining+1]
+	}
+	n, err := reader.ReadCloser.Read(p)
+	reader.remaining -= int64(n)
+	if reader.remaining < 0 {
+		return 0, fmt.Errorf("decompressed object is larger than %d bytes", reader.maxSize)
+	}
+	return n, err
+}
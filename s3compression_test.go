@@ -0,0 +1,216 @@
+package s3provider
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, raw string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(raw))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, raw string) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(raw), nil)
+}
+
+func TestDetectCompression(t *testing.T) {
+	var tests = []struct {
+		contentEncoding string
+		key             string
+		expected        Compression
+	}{
+		{"", "routes.yaml", Uncompressed},
+		{"gzip", "routes.yaml", Gzip},
+		{"aws-chunked, GZIP", "routes.yaml", Gzip},
+		{"zstd", "routes.json", Zstd},
+		{"", "routes.yaml.gz", Gzip},
+		{"", "routes.json.zst", Zstd},
+		{"identity", "routes.json.zst", Zstd},
+		{"zstd", "routes.yaml.gz", Zstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentEncoding+" "+tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.expected, detectCompression(tt.contentEncoding, tt.key))
+		})
+	}
+}
+
+func TestInferParserCompressed(t *testing.T) {
+	var tests = []struct {
+		key      string
+		expected Parser
+	}{
+		{"routes.yaml.gz", Yaml},
+		{"dynamic/routes.json.zst", Json},
+		{"legacy.toml.gz", Toml},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			parser, err := InferParser(tt.key)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, parser)
+		})
+	}
+
+	_, err := InferParser("routes.gz")
+	assert.ErrorContains(t, err, "cannot infer parser for key routes.gz")
+}
+
+func TestRetrieveCompressed(t *testing.T) {
+	var tests = []struct {
+		name            string
+		key             string
+		contentEncoding *string
+		body            []byte
+		parser          Parser
+	}{
+		{"gzip content encoding", testKey, aws.String("gzip"), gzipped(t, testJson), Json},
+		{"zstd content encoding", testKey, aws.String("zstd"), zstdCompressed(t, testYaml), Yaml},
+		{"gz suffix", "routes.yaml.gz", nil, gzipped(t, testYaml), Yaml},
+		{"zst suffix", "routes.toml.zst", nil, zstdCompressed(t, testToml), Toml},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := newMockS3Client()
+			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+				ETag:            aws.String(testETag),
+				ContentEncoding: tt.contentEncoding,
+				Body:            io.NopCloser(bytes.NewReader(tt.body)),
+			}, nil)
+			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+				Bucket: testBucket,
+				Key:    tt.key,
+				Parser: tt.parser,
+			})
+
+			changed, err := retriever.Retrieve(ctx)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, testJsonMap, retriever.data.json)
+		})
+	}
+}
+
+func TestRetrieveDecompressedTooLarge(t *testing.T) {
+	// Highly compressible, so the compressed object is far smaller than the limit
+	raw := `{"padding": "` + strings.Repeat("a", 4096) + `"}`
+
+	var tests = []struct {
+		name        string
+		maxSize     int64
+		expectedErr string
+	}{
+		{"too large", 1024, "decompressed object is larger than 1024 bytes"},
+		{"exactly the limit", int64(len(raw)), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := newMockS3Client()
+			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+				ETag: aws.String(testETag),
+				Body: io.NopCloser(bytes.NewReader(gzipped(t, raw))),
+			}, nil)
+			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+				Bucket:              testBucket,
+				Key:                 "big.json.gz",
+				Parser:              Json,
+				MaxDecompressedSize: tt.maxSize,
+			})
+
+			changed, err := retriever.Retrieve(ctx)
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				assert.False(t, changed)
+				assert.Nil(t, retriever.data)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+		})
+	}
+}
+
+// A zstd frame holding content in a single raw block, declaring a window of 1 << windowLog bytes
+func zstdFrameWithWindow(windowLog int, content string) []byte {
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, byte(windowLog-10) << 3}
+	blockHeader := 1 | len(content)<<3
+	frame = append(frame, byte(blockHeader), byte(blockHeader>>8), byte(blockHeader>>16))
+	return append(frame, content...)
+}
+
+func TestDecompressZstdWindowLimit(t *testing.T) {
+	// A tiny frame asking for a 256MiB window
+	reader, err := decompress(bytes.NewReader(zstdFrameWithWindow(28, testJson)), Zstd, 1<<20)
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, zstd.ErrWindowSizeExceeded)
+
+	reader, err = decompress(bytes.NewReader(zstdFrameWithWindow(20, testJson)), Zstd, 1<<20)
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, testJson, string(decompressed))
+}
+
+func TestRetrieveInvalidCompressedObject(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		ETag:            aws.String(testETag),
+		ContentEncoding: aws.String("gzip"),
+		Body:            io.NopCloser(bytes.NewReader([]byte(testJson))),
+	}, nil)
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket: testBucket,
+		Key:    testKey,
+		Parser: Json,
+	})
+
+	changed, err := retriever.Retrieve(ctx)
+	assert.ErrorContains(t, err, "failed to decompress testbucket/testkey: gzip: invalid header")
+	assert.False(t, changed)
+}
+
+func TestNewMaxDecompressedSizeValidation(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "maxDecompressedSize": -1, "objects": [{"key": "huh.json.gz", "bucket": "someBucket"}]}`), &config)
+	require.NoError(t, err)
+
+	provider, err := New(context.Background(), &config, "test")
+	assert.EqualError(t, err, "max decompressed size cannot be negative")
+	assert.Nil(t, provider)
+
+	config.MaxDecompressedSize = 1024
+	provider, err = New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	assert.Equal(t, Json, provider.retrievers[0].Parser)
+	assert.Equal(t, int64(1024), provider.retrievers[0].MaxDecompressedSize)
+}
@@ -0,0 +1,128 @@
+package s3provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// What to do when two objects define the same router, service, middleware or servers transport
+type ConflictPolicy string
+
+const (
+	// Conflicts are not checked and entries merge with the object's merge strategy. The default
+	ConflictIgnore ConflictPolicy = "ignore"
+	// Conflicts are logged and entries merge with the object's merge strategy
+	ConflictWarn ConflictPolicy = "warn"
+	// Conflicts fail the reload
+	ConflictFail ConflictPolicy = "fail"
+	// Conflicts are logged and the entry from the object declared last replaces the earlier one entirely
+	ConflictLastWins ConflictPolicy = "last-wins"
+)
+
+var ValidConflictPolicies = []ConflictPolicy{ConflictIgnore, ConflictWarn, ConflictFail, ConflictLastWins}
+
+// Parses a conflict policy name. An empty name is the default ignore policy
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return ConflictIgnore, nil
+	}
+	for _, policy := range ValidConflictPolicies {
+		if s == string(policy) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a valid conflict policy", s)
+}
+
+// The sections of the dynamic configuration whose entries are named and referenced by name
+var namedSections = [][]string{
+	{"http", "routers"},
+	{"http", "services"},
+	{"http", "middlewares"},
+	{"http", "serversTransports"},
+	{"tcp", "routers"},
+	{"tcp", "services"},
+	{"tcp", "middlewares"},
+	{"tcp", "serversTransports"},
+	{"udp", "routers"},
+	{"udp", "services"},
+}
+
+// A named entry defined by more than one object
+type conflict struct {
+	// The path of the entry, i.e. [http routers api]
+	path []string
+	// The bucket/key of the object that defined the entry first
+	first string
+	// The bucket/key of the object that defined it again
+	second string
+}
+
+func (c conflict) String() string {
+	return fmt.Sprintf("%s is defined by both %s and %s", strings.Join(c.path, "."), c.first, c.second)
+}
+
+// Tracks which object defined each named entry of the merged configuration
+type conflictDetector struct {
+	// The bucket/key of the object that defined each entry, keyed by the entry's dotted path
+	owners map[string]string
+}
+
+func newConflictDetector() *conflictDetector {
+	return &conflictDetector{
+		owners: make(map[string]string),
+	}
+}
+
+// Records the named entries of data as defined by source and returns the entries that another object already defined
+func (detector *conflictDetector) add(source string, data map[string]interface{}) []conflict {
+	var conflicts []conflict
+	for _, section := range namedSections {
+		entries, ok := lookupJson(data, section).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		names := make([]string, 0, len(entries))
+		for name := range entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			path := append(append([]string{}, section...), name)
+			id := strings.Join(path, ".")
+			if owner, ok := detector.owners[id]; ok && owner != source {
+				conflicts = append(conflicts, conflict{
+					path:   path,
+					first:  owner,
+					second: source,
+				})
+			}
+			detector.owners[id] = source
+		}
+	}
+	return conflicts
+}
+
+// Returns the value at the path of nested maps or nil if there is none
+func lookupJson(data map[string]interface{}, path []string) interface{} {
+	var value interface{} = data
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// Removes the value at the path of nested maps, if there is one
+func deleteJson(data map[string]interface{}, path []string) {
+	parent, ok := lookupJson(data, path[:len(path)-1]).(map[string]interface{})
+	if ok {
+		delete(parent, path[len(path)-1])
+	}
+}
@@ -0,0 +1,152 @@
+package s3provider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	conflictFirst = `{
+	"http": {
+		"routers": {
+			"api": {"rule": "Host(` + "`a.com`" + `)", "service": "api"}
+		},
+		"services": {
+			"api": {"loadBalancer": {"servers": [{"url": "http://a"}]}}
+		}
+	},
+	"udp": {
+		"services": {
+			"dns": {"loadBalancer": {"servers": [{"address": "a:53"}]}}
+		}
+	}
+}`
+	conflictSecond = `{
+	"http": {
+		"routers": {
+			"api": {"rule": "Host(` + "`b.com`" + `)", "middlewares": ["auth"]}
+		},
+		"middlewares": {
+			"auth": {"basicAuth": {"users": ["test:hash"]}}
+		}
+	},
+	"udp": {
+		"services": {
+			"dns": {"loadBalancer": {"servers": [{"address": "b:53"}]}}
+		}
+	}
+}`
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected ConflictPolicy
+	}{
+		{"", ConflictIgnore},
+		{"ignore", ConflictIgnore},
+		{"Warn", ConflictWarn},
+		{"fail", ConflictFail},
+		{" last-wins ", ConflictLastWins},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			policy, err := ParseConflictPolicy(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, policy)
+		})
+	}
+
+	_, err := ParseConflictPolicy("first-wins")
+	assert.ErrorContains(t, err, `"first-wins" is not a valid conflict policy`)
+}
+
+func TestConflictDetector(t *testing.T) {
+	detector := newConflictDetector()
+	assert.Empty(t, detector.add("bucket/first.json", mustUnmarshal(t, conflictFirst)))
+
+	conflicts := detector.add("bucket/second.json", mustUnmarshal(t, conflictSecond))
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "http.routers.api is defined by both bucket/first.json and bucket/second.json", conflicts[0].String())
+	assert.Equal(t, "udp.services.dns is defined by both bucket/first.json and bucket/second.json", conflicts[1].String())
+
+	// The latest definition is the one reported next
+	conflicts = detector.add("other/third.json", mustUnmarshal(t, conflictSecond))
+	require.Len(t, conflicts, 3)
+	assert.Equal(t, "http.routers.api is defined by both bucket/second.json and other/third.json", conflicts[0].String())
+	assert.Equal(t, "http.middlewares.auth is defined by both bucket/second.json and other/third.json", conflicts[1].String())
+}
+
+// The objects that define conflicting entries
+var conflictObjects = []string{`{"key": "first.json", "bucket": "someBucket"}`, `{"key": "second.json", "bucket": "otherBucket"}`}
+
+func TestMergeConflictPolicies(t *testing.T) {
+	var tests = []struct {
+		policy      string
+		expectedApi map[string]interface{}
+	}{
+		// Conflicting entries merge as usual, where the first object's values win
+		{"ignore", map[string]interface{}{
+			"rule":        "Host(`a.com`)",
+			"service":     "api",
+			"middlewares": []interface{}{"auth"},
+		}},
+		{"warn", map[string]interface{}{
+			"rule":        "Host(`a.com`)",
+			"service":     "api",
+			"middlewares": []interface{}{"auth"},
+		}},
+		// The later definition replaces the whole entry
+		{"last-wins", map[string]interface{}{
+			"rule":        "Host(`b.com`)",
+			"middlewares": []interface{}{"auth"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			provider, _ := newTestProvider(t, `"conflictPolicy": "`+tt.policy+`"`, conflictObjects...)
+			provider.retrievers[0].data = &ConfigData{json: mustUnmarshal(t, conflictFirst)}
+			provider.retrievers[1].data = &ConfigData{json: mustUnmarshal(t, conflictSecond)}
+
+			composite, err := provider.merge()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedApi, lookupJson(composite, []string{"http", "routers", "api"}))
+			// Entries that are only defined once are unaffected
+			assert.NotNil(t, lookupJson(composite, []string{"http", "services", "api"}))
+			assert.NotNil(t, lookupJson(composite, []string{"http", "middlewares", "auth"}))
+		})
+	}
+}
+
+func TestMergeConflictFail(t *testing.T) {
+	provider, _ := newTestProvider(t, `"conflictPolicy": "fail"`, conflictObjects...)
+	provider.retrievers[0].data = &ConfigData{json: mustUnmarshal(t, conflictFirst)}
+	provider.retrievers[1].data = &ConfigData{json: mustUnmarshal(t, conflictSecond)}
+
+	composite, err := provider.merge()
+	assert.EqualError(t, err, "conflicting definitions: "+
+		"http.routers.api is defined by both someBucket/first.json and otherBucket/second.json; "+
+		"udp.services.dns is defined by both someBucket/first.json and otherBucket/second.json")
+	assert.Nil(t, composite)
+}
+
+func TestNewConflictPolicyValidation(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "conflictPolicy": "first-wins", "objects": [
+		{
+			"key": "huh.json",
+			"bucket": "someBucket"
+		}
+	]}`), &config)
+	require.NoError(t, err)
+
+	provider, err := New(context.Background(), &config, "test")
+	assert.ErrorContains(t, err, `"first-wins" is not a valid conflict policy`)
+	assert.Nil(t, provider)
+}
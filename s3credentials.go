@@ -0,0 +1,200 @@
+package s3provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	fileCredentialsSource = "S3ProviderFileCredentials"
+	// The session name used for assumed roles if none is configured
+	defaultRoleSessionName = "traefik-s3provider"
+	// How long before an assumed role session expires that we start renewing it
+	assumeRoleExpiryWindow = 5 * time.Minute
+)
+
+// Reads credentials out of one or more files and re-reads them whenever any of the files'
+// modification times change
+type fileCredentials struct {
+	// The files that the credentials are read from
+	paths []string
+	// Reads the credentials from the files
+	load func(ctx context.Context) (aws.Credentials, error)
+
+	// Coalesces concurrent reloads of the same files into one read
+	group singleflight.Group
+
+	mu sync.RWMutex
+	// The modification time of each path when creds was read
+	modTimes []time.Time
+	// The last successfully read credentials
+	creds *aws.Credentials
+}
+
+// Creates a credentials provider that stats the shared credentials file on every retrieval
+// and only re-reads it if it was modified since the last read.  This lets credentials be
+// rotated by rewriting the file without restarting traefik.
+func NewFileCredentialsProvider(path string, profile string) CredentialsGetter {
+	return newSharedFileCredentials(path, profile).Retrieve
+}
+
+// Creates a credentials provider from an access key id and secret access key that may each either be
+// supplied directly or read from a file (i.e. a docker or kubernetes secret).  Files are re-read when modified.
+func NewStaticCredentialsProvider(accessKeyID, accessKeyIDFile, secretAccessKey, secretAccessKeyFile, sessionToken string) CredentialsGetter {
+	var paths []string
+	if accessKeyIDFile != "" {
+		paths = append(paths, accessKeyIDFile)
+	}
+	if secretAccessKeyFile != "" {
+		paths = append(paths, secretAccessKeyFile)
+	}
+
+	load := func(ctx context.Context) (aws.Credentials, error) {
+		var err error
+		creds := aws.Credentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			SessionToken:    sessionToken,
+			Source:          fileCredentialsSource,
+		}
+		if accessKeyIDFile != "" {
+			if creds.AccessKeyID, err = readSecretFile(accessKeyIDFile); err != nil {
+				return aws.Credentials{}, err
+			}
+		}
+		if secretAccessKeyFile != "" {
+			if creds.SecretAccessKey, err = readSecretFile(secretAccessKeyFile); err != nil {
+				return aws.Credentials{}, err
+			}
+		}
+		if !creds.HasKeys() {
+			return aws.Credentials{}, fmt.Errorf("access key id and secret access key cannot be empty")
+		}
+		return creds, nil
+	}
+
+	return newFileCredentials(paths, load).Retrieve
+}
+
+// Creates a credentials provider that assumes roleArn using the credentials already on cfg. The session
+// is cached and renewed somewhere within the 5 minutes before it expires so that requests never use an
+// expired session and many replicas do not renew all at once.
+func NewAssumeRoleProvider(cfg aws.Config, roleArn, externalID, sessionName string, duration time.Duration) aws.CredentialsProvider {
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(opts *stscreds.AssumeRoleOptions) {
+		opts.RoleSessionName = sessionName
+		if externalID != "" {
+			opts.ExternalID = aws.String(externalID)
+		}
+		if duration > 0 {
+			opts.Duration = duration
+		}
+	})
+
+	return aws.NewCredentialsCache(provider, func(opts *aws.CredentialsCacheOptions) {
+		opts.ExpiryWindow = assumeRoleExpiryWindow
+		opts.ExpiryWindowJitterFrac = 0.5
+	})
+}
+
+func newFileCredentials(paths []string, load func(ctx context.Context) (aws.Credentials, error)) *fileCredentials {
+	return &fileCredentials{
+		paths: paths,
+		load:  load,
+	}
+}
+
+func newSharedFileCredentials(path string, profile string) *fileCredentials {
+	return newFileCredentials([]string{path}, func(ctx context.Context) (aws.Credentials, error) {
+		return readSharedCredentialsFile(ctx, path, profile)
+	})
+}
+
+// Returns the cached credentials, reloading them if any file has changed
+func (fc *fileCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	modTimes := make([]time.Time, len(fc.paths))
+	for idx, path := range fc.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return aws.Credentials{}, fmt.Errorf("unable to stat credentials file %s: %w", path, err)
+		}
+		modTimes[idx] = info.ModTime()
+	}
+
+	fc.mu.RLock()
+	creds := fc.creds
+	current := creds != nil && sameModTimes(fc.modTimes, modTimes)
+	fc.mu.RUnlock()
+	if current {
+		return *creds, nil
+	}
+
+	result, err, _ := fc.group.Do(strings.Join(fc.paths, ","), func() (interface{}, error) {
+		loaded, err := fc.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		fc.creds = &loaded
+		fc.modTimes = modTimes
+		return loaded, nil
+	})
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return result.(aws.Credentials), nil
+}
+
+func sameModTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if !a[idx].Equal(b[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func readSharedCredentialsFile(ctx context.Context, path string, profile string) (aws.Credentials, error) {
+	sharedConfig, err := config.LoadSharedConfigProfile(ctx, profile, func(opts *config.LoadSharedConfigOptions) {
+		opts.CredentialsFiles = []string{path}
+		opts.ConfigFiles = []string{}
+	})
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("unable to read profile %s from credentials file %s: %w", profile, path, err)
+	}
+
+	creds := sharedConfig.Credentials
+	if !creds.HasKeys() {
+		return aws.Credentials{}, fmt.Errorf("profile %s in credentials file %s does not have access keys", profile, path)
+	}
+	creds.Source = fileCredentialsSource
+
+	return creds, nil
+}
+
+// Reads a single value secret file, ignoring surrounding whitespace
+func readSecretFile(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read credentials file %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
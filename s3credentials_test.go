@@ -0,0 +1,217 @@
+package s3provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCredentials1 = `[default]
+aws_access_key_id = key1
+aws_secret_access_key = secret1
+
+[other]
+aws_access_key_id = otherkey
+aws_secret_access_key = othersecret
+`
+	testCredentials2 = `[default]
+aws_access_key_id = key2
+aws_secret_access_key = secret2
+aws_session_token = token2
+`
+)
+
+func writeCredentialsFile(t *testing.T, path string, contents string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFileCredentialsInitial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	writeCredentialsFile(t, path, testCredentials1, time.Now())
+
+	creds, err := NewFileCredentialsProvider(path, "default").Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key1", creds.AccessKeyID)
+	assert.Equal(t, "secret1", creds.SecretAccessKey)
+	assert.Equal(t, "", creds.SessionToken)
+}
+
+func TestFileCredentialsProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	writeCredentialsFile(t, path, testCredentials1, time.Now())
+
+	creds, err := NewFileCredentialsProvider(path, "other").Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "otherkey", creds.AccessKeyID)
+	assert.Equal(t, "othersecret", creds.SecretAccessKey)
+}
+
+func TestFileCredentialsMissingProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	writeCredentialsFile(t, path, testCredentials1, time.Now())
+
+	_, err := NewFileCredentialsProvider(path, "nope").Retrieve(context.Background())
+	require.ErrorContains(t, err, "unable to read profile nope")
+}
+
+func TestFileCredentialsMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+
+	_, err := NewFileCredentialsProvider(path, "default").Retrieve(context.Background())
+	require.ErrorContains(t, err, "unable to stat credentials file")
+}
+
+func TestFileCredentialsReloadsOnChange(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "credentials")
+	now := time.Now()
+	writeCredentialsFile(t, path, testCredentials1, now)
+
+	provider := NewFileCredentialsProvider(path, "default")
+	creds, err := provider.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key1", creds.AccessKeyID)
+
+	writeCredentialsFile(t, path, testCredentials2, now.Add(5*time.Second))
+
+	creds, err = provider.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key2", creds.AccessKeyID)
+	assert.Equal(t, "secret2", creds.SecretAccessKey)
+	assert.Equal(t, "token2", creds.SessionToken)
+}
+
+func TestFileCredentialsNoReloadWhenUnchanged(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "credentials")
+	writeCredentialsFile(t, path, testCredentials1, time.Now())
+
+	fc := newSharedFileCredentials(path, "default")
+	var loads int32
+	readFile := fc.load
+	fc.load = func(ctx context.Context) (aws.Credentials, error) {
+		atomic.AddInt32(&loads, 1)
+		return readFile(ctx)
+	}
+
+	for i := 0; i < 5; i++ {
+		creds, err := fc.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "key1", creds.AccessKeyID)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestFileCredentialsCoalescesConcurrentReloads(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "credentials")
+	writeCredentialsFile(t, path, testCredentials1, time.Now())
+
+	fc := newSharedFileCredentials(path, "default")
+	var loads int32
+	release := make(chan struct{})
+	readFile := fc.load
+	fc.load = func(ctx context.Context) (aws.Credentials, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return readFile(ctx)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]aws.Credentials, 10)
+	errs := make([]error, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = fc.Retrieve(ctx)
+		}(i)
+	}
+
+	// Give every goroutine a chance to join the in flight load
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	for i := range results {
+		require.NoError(t, errs[i])
+		assert.Equal(t, "key1", results[i].AccessKeyID)
+	}
+}
+
+func TestFileCredentialsKeepsErrorUntilFixed(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "credentials")
+	now := time.Now()
+	writeCredentialsFile(t, path, "[default]\n", now)
+
+	provider := NewFileCredentialsProvider(path, "default")
+	_, err := provider.Retrieve(ctx)
+	require.ErrorContains(t, err, "does not have access keys")
+
+	writeCredentialsFile(t, path, testCredentials1, now.Add(time.Second))
+	creds, err := provider.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key1", creds.AccessKeyID)
+}
+
+func TestStaticCredentials(t *testing.T) {
+	creds, err := NewStaticCredentialsProvider("key", "", "secret", "", "token").Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key", creds.AccessKeyID)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+	assert.Equal(t, "token", creds.SessionToken)
+}
+
+func TestStaticCredentialsFromFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	idPath := filepath.Join(dir, "access_key_id")
+	secretPath := filepath.Join(dir, "secret_access_key")
+	now := time.Now()
+	writeCredentialsFile(t, idPath, "key1\n", now)
+	writeCredentialsFile(t, secretPath, "  secret1\n", now)
+
+	provider := NewStaticCredentialsProvider("", idPath, "", secretPath, "")
+	creds, err := provider.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key1", creds.AccessKeyID)
+	assert.Equal(t, "secret1", creds.SecretAccessKey)
+
+	// Rotating only the secret is picked up
+	writeCredentialsFile(t, secretPath, "secret2", now.Add(time.Second))
+	creds, err = provider.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "key1", creds.AccessKeyID)
+	assert.Equal(t, "secret2", creds.SecretAccessKey)
+}
+
+func TestStaticCredentialsMixedFileAndValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret_access_key")
+	writeCredentialsFile(t, path, "filesecret", time.Now())
+
+	creds, err := NewStaticCredentialsProvider("key", "", "", path, "").Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "key", creds.AccessKeyID)
+	assert.Equal(t, "filesecret", creds.SecretAccessKey)
+}
+
+func TestStaticCredentialsEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret_access_key")
+	writeCredentialsFile(t, path, "\n", time.Now())
+
+	_, err := NewStaticCredentialsProvider("key", "", "", path, "").Retrieve(context.Background())
+	require.ErrorContains(t, err, "access key id and secret access key cannot be empty")
+}
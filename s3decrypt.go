@@ -0,0 +1,344 @@
+package s3provider
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// The start of a binary age file
+const ageBinaryHeader = "age-encryption.org/"
+
+// The key in a sops document that holds its metadata
+const sopsMetadataKey = "sops"
+
+// A value encrypted by sops
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// Decrypts objects that are encrypted with age, either as a whole or as a sops document, using the
+// identities in an age key file
+type Decrypter struct {
+	// The file the identities were read from, for errors
+	keyFile    string
+	identities []age.Identity
+}
+
+// Reads the identities in an age key file, as created by age-keygen
+func NewDecrypter(keyFile string) (*Decrypter, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read age key file %s: %w", keyFile, err)
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age key file %s: %w", keyFile, err)
+	}
+	return &Decrypter{
+		keyFile:    keyFile,
+		identities: identities,
+	}, nil
+}
+
+// Decrypts an age encrypted (armored or binary) object, or the values of a sops encrypted json or yaml
+// object. Objects that are not encrypted are returned as is
+func (decrypter *Decrypter) Decrypt(raw []byte, parser Parser) ([]byte, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		return decrypter.decryptAge(armor.NewReader(bytes.NewReader(trimmed)))
+	}
+	if bytes.HasPrefix(raw, []byte(ageBinaryHeader)) {
+		return decrypter.decryptAge(bytes.NewReader(raw))
+	}
+	if (parser == Json || parser == Yaml) && bytes.Contains(raw, []byte("ENC[AES256_GCM,")) {
+		return decrypter.decryptSops(raw, parser)
+	}
+	return raw, nil
+}
+
+func (decrypter *Decrypter) decryptAge(src io.Reader) ([]byte, error) {
+	reader, err := age.Decrypt(src, decrypter.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt age payload with %s: %w", decrypter.keyFile, err)
+	}
+	return io.ReadAll(reader)
+}
+
+// The parts of the sops metadata needed to decrypt with age
+type sopsMetadata struct {
+	Age       []sopsAgeKey `yaml:"age"`
+	KeyGroups []struct {
+		Age []sopsAgeKey `yaml:"age"`
+	} `yaml:"key_groups"`
+	ShamirThreshold  int    `yaml:"shamir_threshold"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	// The armored, age encrypted data key
+	Enc string `yaml:"enc"`
+}
+
+// Decrypts every encrypted value of a sops document, checks its MAC and returns the document without its
+// sops metadata in the same format. Json is read as yaml so that the order of its keys, which the MAC
+// depends on, is kept
+func (decrypter *Decrypter) decryptSops(raw []byte, parser Parser) ([]byte, error) {
+	doc, metadata, err := parseSopsDocument(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := decrypter.sopsDataKey(metadata)
+	if err != nil {
+		return nil, err
+	}
+	walker := sopsWalker{
+		dataKey:          dataKey,
+		macOnlyEncrypted: metadata.MACOnlyEncrypted,
+		mac:              sha512.New(),
+	}
+	if err := walker.walk(doc.Content[0], nil); err != nil {
+		return nil, err
+	}
+	if err := walker.checkMAC(metadata); err != nil {
+		return nil, err
+	}
+
+	if parser == Json {
+		value, err := ensureNodesAreFloat(doc)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	}
+	return yaml.Marshal(doc)
+}
+
+// Parses a single document sops file and removes its sops metadata from it
+func parseSopsDocument(raw []byte) (*yaml.Node, sopsMetadata, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	var doc yaml.Node
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, sopsMetadata{}, fmt.Errorf("unable to parse sops document: %w", err)
+	}
+	var next yaml.Node
+	if err := decoder.Decode(&next); !errors.Is(err, io.EOF) {
+		return nil, sopsMetadata{}, errors.New("multi-document sops files are not supported")
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, sopsMetadata{}, errors.New("sops document is not a mapping")
+	}
+	metadata, err := removeSopsMetadata(doc.Content[0])
+	if err != nil {
+		return nil, sopsMetadata{}, err
+	}
+	return &doc, metadata, nil
+}
+
+// Removes the sops key from the root mapping and returns its metadata
+func removeSopsMetadata(root *yaml.Node) (sopsMetadata, error) {
+	var metadata sopsMetadata
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value != sopsMetadataKey {
+			continue
+		}
+		metadataNode := root.Content[i+1]
+		root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+		if err := metadataNode.Decode(&metadata); err != nil {
+			return metadata, fmt.Errorf("invalid sops metadata: %w", err)
+		}
+		return metadata, nil
+	}
+	return metadata, errors.New("object has encrypted values but no sops metadata")
+}
+
+// Decrypts the sops data key with the first age key that one of the identities can decrypt
+func (decrypter *Decrypter) sopsDataKey(metadata sopsMetadata) ([]byte, error) {
+	if metadata.ShamirThreshold > 1 {
+		return nil, errors.New("sops files split with shamir_threshold are not supported")
+	}
+	keys := metadata.Age
+	for _, group := range metadata.KeyGroups {
+		keys = append(keys, group.Age...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("sops file has no age recipients")
+	}
+
+	recipients := make([]string, 0, len(keys))
+	for _, key := range keys {
+		recipients = append(recipients, key.Recipient)
+		reader, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(key.Enc))), decrypter.identities...)
+		if err != nil {
+			continue
+		}
+		dataKey, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read sops data key: %w", err)
+		}
+		return dataKey, nil
+	}
+	return nil, fmt.Errorf("no identity in %s can decrypt the sops data key for recipients %s", decrypter.keyFile, strings.Join(recipients, ", "))
+}
+
+// Decrypts the values of a sops document in place, hashing them in order for the MAC
+type sopsWalker struct {
+	dataKey          []byte
+	macOnlyEncrypted bool
+	mac              hash.Hash
+}
+
+// Walks the document like sops does: the path of a value is the keys of the mappings it is in, and the
+// elements of a sequence share the sequence's path
+func (walker *sopsWalker) walk(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			keyPath := append(path[:len(path):len(path)], node.Content[i].Value)
+			if err := walker.walk(node.Content[i+1], keyPath); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, el := range node.Content {
+			if err := walker.walk(el, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return walker.walkScalar(node, path)
+	case yaml.AliasNode:
+		return fmt.Errorf("yaml aliases are not supported in sops files (at %s)", strings.Join(path, "."))
+	}
+	return nil
+}
+
+// Decrypts a value if it is encrypted and adds it to the MAC, which only covers the encrypted values of
+// mac_only_encrypted files
+func (walker *sopsWalker) walkScalar(node *yaml.Node, path []string) error {
+	encrypted := node.Tag == "!!str" && sopsValue.MatchString(node.Value)
+	if encrypted {
+		if err := walker.decryptNode(node, path); err != nil {
+			return err
+		}
+	}
+	if node.Tag != "!!null" && (!walker.macOnlyEncrypted || encrypted) {
+		walker.mac.Write(sopsMACBytes(node))
+	}
+	return nil
+}
+
+func (walker *sopsWalker) decryptNode(node *yaml.Node, path []string) error {
+	plaintext, valueType, err := decryptSopsValue(node.Value, walker.dataKey, strings.Join(path, ":")+":")
+	if err != nil {
+		return fmt.Errorf("unable to decrypt the value at %s: %w", strings.Join(path, "."), err)
+	}
+	node.Value = plaintext
+	node.Style = 0
+	switch valueType {
+	case "int":
+		node.Tag = "!!int"
+	case "float":
+		node.Tag = "!!float"
+	case "bool":
+		node.Tag = "!!bool"
+	default:
+		node.Tag = "!!str"
+		// Quote so that strings like "true" or "8080" stay strings
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return nil
+}
+
+// Checks that the MAC of the decrypted values matches the document's, so that values cannot be removed,
+// reordered or swapped between documents
+func (walker *sopsWalker) checkMAC(metadata sopsMetadata) error {
+	lastModified, err := time.Parse(time.RFC3339, metadata.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid sops lastmodified %q: %w", metadata.LastModified, err)
+	}
+	expected, _, err := decryptSopsValue(metadata.MAC, walker.dataKey, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("unable to decrypt sops MAC: %w", err)
+	}
+	if actual := fmt.Sprintf("%X", walker.mac.Sum(nil)); actual != expected {
+		return errors.New("sops MAC mismatch, the file may have been modified without sops")
+	}
+	return nil
+}
+
+// Decrypts a sops ENC[AES256_GCM,...] value, returning its plaintext and its type (str, int, float, bool or bytes)
+func decryptSopsValue(value string, dataKey []byte, additionalData string) (string, string, error) {
+	matches := sopsValue.FindStringSubmatch(value)
+	if matches == nil {
+		return "", "", errors.New("not a sops encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64: %w", err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", err
+	}
+	return string(plaintext), matches[4], nil
+}
+
+// The bytes of a value that sops adds to its MAC. sops hashes the value it decoded rather than its text, so
+// values are decoded as yaml (i.e. 0o17, 1_000 or .inf) and formatted the way sops formats them
+func sopsMACBytes(node *yaml.Node) []byte {
+	switch node.Tag {
+	case "!!int":
+		var i int64
+		if node.Decode(&i) == nil {
+			return []byte(strconv.FormatInt(i, 10))
+		}
+	case "!!float":
+		var f float64
+		if node.Decode(&f) == nil {
+			return []byte(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	case "!!bool":
+		var b bool
+		if node.Decode(&b) == nil {
+			if b {
+				return []byte("True")
+			}
+			return []byte("False")
+		}
+	}
+	return []byte(node.Value)
+}
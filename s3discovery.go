@@ -0,0 +1,207 @@
+package s3provider
+
+import (
+	"context"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The s3 api needed to discover objects and then retrieve them
+type MinS3ListApi interface {
+	MinS3Api
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// Produces the retrievers for one entry of Config.Objects in the order they should be merged
+type retrieverSource interface {
+	// Returns the current retrievers and whether the set of retrievers changed since the last call.
+	// On error, the last known retrievers are still returned
+	Retrievers(ctx context.Context) ([]*S3ObjectRetriever, bool, error)
+	// Returns the retrievers from the last call to Retrievers without contacting s3
+	current() []*S3ObjectRetriever
+	// Whether any of the object events could add, remove or change one of the source's objects
+	affectedBy(events []S3ObjectEvent) bool
+}
+
+// A single, explicitly configured object
+type staticSource struct {
+	retriever *S3ObjectRetriever
+}
+
+func (source staticSource) Retrievers(ctx context.Context) ([]*S3ObjectRetriever, bool, error) {
+	return []*S3ObjectRetriever{source.retriever}, false, nil
+}
+
+func (source staticSource) current() []*S3ObjectRetriever {
+	return []*S3ObjectRetriever{source.retriever}
+}
+
+func (source staticSource) affectedBy(events []S3ObjectEvent) bool {
+	return source.retriever.affectedBy(events)
+}
+
+type PrefixConfig struct {
+	// The bucket name
+	Bucket string
+	// The key prefix to list objects under
+	Prefix string
+	// An optional glob that keys (relative to the prefix) must match
+	Glob string
+	// An optional regular expression that keys (relative to the prefix) must match
+	Regex *regexp.Regexp
+	// The parser to use for every object. If Unknown, it is inferred from each key's extension
+	Parser Parser
+	// Renders each object before it is parsed. Not rendered if nil
+	Template *Templater
+	// The most bytes that each compressed object can decompress to
+	MaxDecompressedSize int64
+	// The customer provided key that each object is encrypted with, if any
+	SSECustomerKey *SSECustomerKey
+	// Decrypts each object that is age or sops encrypted. Not decrypted if nil
+	Decrypter *Decrypter
+	// How each discovered object is changed and merged into the objects before it
+	ObjectOptions
+}
+
+// Lists a prefix in a bucket and keeps one retriever per matching object
+type S3PrefixDiscoverer struct {
+	PrefixConfig
+	// The s3 client configured
+	client MinS3ListApi
+	// The retrievers for the keys found in the last listing
+	retrievers map[string]*S3ObjectRetriever
+}
+
+// Creates a new discoverer that will create retrievers for every matching object under a prefix
+func NewS3PrefixDiscoverer(client MinS3ListApi, config PrefixConfig) *S3PrefixDiscoverer {
+	return &S3PrefixDiscoverer{
+		PrefixConfig: config,
+		client:       client,
+		retrievers:   make(map[string]*S3ObjectRetriever),
+	}
+}
+
+// Lists the prefix and returns a retriever for every matching key in key order.  Retrievers for keys that
+// are still present are reused so that their previously retrieved data is kept.
+func (discoverer *S3PrefixDiscoverer) Retrievers(ctx context.Context) ([]*S3ObjectRetriever, bool, error) {
+	keys, err := discoverer.list(ctx)
+	if err != nil {
+		log.Printf("unable to list objects under %s/%s: %v", discoverer.Bucket, discoverer.Prefix, err)
+		// Return the last listing so that callers can choose to keep using it
+		return discoverer.current(), false, err
+	}
+
+	changed := false
+	found := make(map[string]*S3ObjectRetriever, len(keys))
+	retrievers := make([]*S3ObjectRetriever, 0, len(keys))
+	for _, key := range keys {
+		retriever, ok := discoverer.retrievers[key]
+		if !ok {
+			parser := discoverer.Parser
+			if parser == Unknown {
+				parser, err = InferParser(key)
+				if err != nil {
+					log.Printf("skipping discovered object %s/%s: %v", discoverer.Bucket, key, err)
+					continue
+				}
+			}
+			retriever = NewS3ObjectRetriever(discoverer.client, RetrieverConfig{
+				Bucket:              discoverer.Bucket,
+				Key:                 key,
+				Parser:              parser,
+				Template:            discoverer.Template,
+				MaxDecompressedSize: discoverer.MaxDecompressedSize,
+				SSECustomerKey:      discoverer.SSECustomerKey,
+				Decrypter:           discoverer.Decrypter,
+				// The object can be deleted between listing and retrieving it
+				Optional:      true,
+				ObjectOptions: discoverer.ObjectOptions,
+			})
+			changed = true
+		}
+		found[key] = retriever
+		retrievers = append(retrievers, retriever)
+	}
+
+	// Anything that we had before but did not find was removed
+	for key := range discoverer.retrievers {
+		if _, ok := found[key]; !ok {
+			changed = true
+		}
+	}
+	discoverer.retrievers = found
+
+	return retrievers, changed, nil
+}
+
+// Returns the retrievers from the last successful listing in key order
+func (discoverer *S3PrefixDiscoverer) current() []*S3ObjectRetriever {
+	keys := make([]string, 0, len(discoverer.retrievers))
+	for key := range discoverer.retrievers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var retrievers []*S3ObjectRetriever
+	for _, key := range keys {
+		retrievers = append(retrievers, discoverer.retrievers[key])
+	}
+	return retrievers
+}
+
+func (discoverer *S3PrefixDiscoverer) affectedBy(events []S3ObjectEvent) bool {
+	for _, event := range events {
+		if event.Bucket == discoverer.Bucket && strings.HasPrefix(event.Key, discoverer.Prefix) && discoverer.matches(event.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns every matching key under the prefix in sorted order
+func (discoverer *S3PrefixDiscoverer) list(ctx context.Context) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(discoverer.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(discoverer.Bucket),
+		Prefix: aws.String(discoverer.Prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if discoverer.matches(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (discoverer *S3PrefixDiscoverer) matches(key string) bool {
+	// Skip "folder" placeholder objects
+	if strings.HasSuffix(key, "/") {
+		return false
+	}
+	relative := strings.TrimPrefix(key, discoverer.Prefix)
+	if discoverer.Glob != "" {
+		// The glob is validated on creation so we can ignore the error
+		if ok, _ := path.Match(discoverer.Glob, relative); !ok {
+			return false
+		}
+	}
+	if discoverer.Regex != nil && !discoverer.Regex.MatchString(relative) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package s3provider
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrefix = "traefik/dynamic/"
+
+func retrieverKeys(retrievers []*S3ObjectRetriever) []string {
+	keys := make([]string, len(retrievers))
+	for i, retriever := range retrievers {
+		keys[i] = retriever.Key
+	}
+	return keys
+}
+
+func TestDiscoverSortedAndInferred(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("ListObjectsV2", ctx, mock.MatchedBy(func(arg *s3.ListObjectsV2Input) bool {
+		return *arg.Bucket == testBucket && *arg.Prefix == testPrefix
+	}), mock.Anything).Return(newListPage(
+		testPrefix+"b.yaml",
+		testPrefix,
+		testPrefix+"a.json",
+		testPrefix+"readme.txt",
+		testPrefix+"nested/c.yml",
+	), nil)
+	discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+		Bucket: testBucket,
+		Prefix: testPrefix,
+	})
+
+	retrievers, changed, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{
+		testPrefix + "a.json",
+		testPrefix + "b.yaml",
+		testPrefix + "nested/c.yml",
+	}, retrieverKeys(retrievers))
+	assert.Equal(t, Json, retrievers[0].Parser)
+	assert.Equal(t, Yaml, retrievers[1].Parser)
+	assert.Equal(t, Yaml, retrievers[2].Parser)
+	assert.Equal(t, testBucket, retrievers[0].Bucket)
+}
+
+func TestDiscoverExplicitParser(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+		testPrefix+"routers",
+		testPrefix+"services.txt",
+	), nil)
+	discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+		Bucket: testBucket,
+		Prefix: testPrefix,
+		Parser: Yaml,
+	})
+
+	retrievers, _, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	require.Len(t, retrievers, 2)
+	assert.Equal(t, Yaml, retrievers[0].Parser)
+	assert.Equal(t, Yaml, retrievers[1].Parser)
+}
+
+func TestDiscoverFilters(t *testing.T) {
+	var tests = []struct {
+		name     string
+		glob     string
+		regex    *regexp.Regexp
+		expected []string
+	}{
+		{"glob", "*.yaml", nil, []string{testPrefix + "a.yaml", testPrefix + "b.yaml"}},
+		{"glob nested", "*/*.yaml", nil, []string{testPrefix + "team/c.yaml"}},
+		{"regex", "", regexp.MustCompile(`^(a|team/c)\.`), []string{testPrefix + "a.json", testPrefix + "a.yaml", testPrefix + "team/c.yaml"}},
+		{"glob and regex", "*.yaml", regexp.MustCompile(`^a`), []string{testPrefix + "a.yaml"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := newMockS3Client()
+			mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+				testPrefix+"a.yaml",
+				testPrefix+"a.json",
+				testPrefix+"b.yaml",
+				testPrefix+"team/c.yaml",
+			), nil)
+			discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+				Bucket: testBucket,
+				Prefix: testPrefix,
+				Glob:   tt.glob,
+				Regex:  tt.regex,
+			})
+
+			retrievers, _, err := discoverer.Retrievers(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, retrieverKeys(retrievers))
+		})
+	}
+}
+
+func TestDiscoverPaginates(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	firstPage := newListPage(testPrefix + "c.yaml")
+	firstPage.IsTruncated = aws.Bool(true)
+	firstPage.NextContinuationToken = aws.String("next")
+	mockClient.On("ListObjectsV2", ctx, mock.MatchedBy(func(arg *s3.ListObjectsV2Input) bool {
+		return arg.ContinuationToken == nil
+	}), mock.Anything).Return(firstPage, nil)
+	mockClient.On("ListObjectsV2", ctx, mock.MatchedBy(func(arg *s3.ListObjectsV2Input) bool {
+		return arg.ContinuationToken != nil && *arg.ContinuationToken == "next"
+	}), mock.Anything).Return(newListPage(testPrefix+"a.yaml"), nil)
+	discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+		Bucket: testBucket,
+		Prefix: testPrefix,
+	})
+
+	retrievers, _, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testPrefix + "a.yaml", testPrefix + "c.yaml"}, retrieverKeys(retrievers))
+	mockClient.AssertNumberOfCalls(t, "ListObjectsV2", 2)
+}
+
+func TestDiscoverAddsAndRemoves(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+		testPrefix+"a.yaml",
+		testPrefix+"b.yaml",
+	), nil).Once()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+		testPrefix+"a.yaml",
+		testPrefix+"b.yaml",
+	), nil).Once()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+		testPrefix+"b.yaml",
+		testPrefix+"c.yaml",
+	), nil).Once()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+		testPrefix+"b.yaml",
+	), nil).Once()
+	discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+		Bucket: testBucket,
+		Prefix: testPrefix,
+	})
+
+	first, changed, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed, "initial listing is a change")
+
+	second, changed, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed, "same listing is not a change")
+	assert.Same(t, first[0], second[0], "retrievers are reused")
+	assert.Same(t, first[1], second[1], "retrievers are reused")
+
+	third, changed, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed, "added and removed keys are a change")
+	assert.Equal(t, []string{testPrefix + "b.yaml", testPrefix + "c.yaml"}, retrieverKeys(third))
+	assert.Same(t, first[1], third[0], "retrievers are reused")
+
+	fourth, changed, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed, "removed key is a change")
+	assert.Equal(t, []string{testPrefix + "b.yaml"}, retrieverKeys(fourth))
+}
+
+func TestDiscoverListError(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!"))
+	discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+		Bucket: testBucket,
+		Prefix: testPrefix,
+	})
+
+	retrievers, changed, err := discoverer.Retrievers(ctx)
+	require.ErrorContains(t, err, "Oh no!")
+	assert.False(t, changed)
+	assert.Nil(t, retrievers)
+}
+
+func TestDiscoverListErrorKeepsLastListing(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(newListPage(
+		testPrefix+"b.yaml",
+		testPrefix+"a.yaml",
+	), nil).Once()
+	mockClient.On("ListObjectsV2", ctx, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!"))
+	discoverer := NewS3PrefixDiscoverer(mockClient, PrefixConfig{
+		Bucket: testBucket,
+		Prefix: testPrefix,
+	})
+
+	first, _, err := discoverer.Retrievers(ctx)
+	require.NoError(t, err)
+
+	retrievers, changed, err := discoverer.Retrievers(ctx)
+	require.ErrorContains(t, err, "Oh no!")
+	assert.False(t, changed)
+	assert.Equal(t, first, retrievers)
+}
@@ -0,0 +1,261 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gopkg.in/yaml.v3"
+)
+
+// The largest manifest object that is read
+const maxManifestSize = 1 << 20
+
+// Returned when an object pinned to an ETag, i.e. by a manifest, currently has a different ETag
+var ErrETagMismatch = errors.New("object does not match its expected ETag")
+
+// The contents of a manifest object. Json manifests are parsed as yaml
+type manifest struct {
+	Objects []manifestEntry `yaml:"objects"`
+}
+
+// An object listed in a manifest. Either the ETag or the VersionID (or both) must be set
+type manifestEntry struct {
+	// The bucket of the object. Defaults to the manifest's bucket
+	Bucket string `yaml:"bucket"`
+	Key    string `yaml:"key"`
+	// The ETag the object must have, with or without its quotes
+	ETag string `yaml:"etag"`
+	// The version of the object to retrieve
+	VersionID string `yaml:"versionId"`
+	// The parser for the object. Inferred from the key's extension if empty
+	Parser string `yaml:"parser"`
+}
+
+type ManifestConfig struct {
+	// The bucket name
+	Bucket string
+	// The key of the manifest object
+	Key string
+	// Renders each listed object before it is parsed. Not rendered if nil
+	Template *Templater
+	// The most bytes that each compressed object can decompress to
+	MaxDecompressedSize int64
+	// The customer provided key that the manifest and each listed object are encrypted with, if any
+	SSECustomerKey *SSECustomerKey
+	// Decrypts each listed object that is age or sops encrypted. Not decrypted if nil
+	Decrypter *Decrypter
+	// The most listed objects that are retrieved at the same time. Defaults to 10
+	MaxConcurrentRequests int
+	// How long each listed object request can take. No limit if 0
+	RequestTimeout time.Duration
+	// How each listed object is changed and merged into the objects before it
+	ObjectOptions
+}
+
+// Reads a manifest object listing other objects at exact ETags or versions, and only switches to the listed
+// objects once every one of them has been retrieved as listed, so that a change spanning several objects is
+// applied all at once
+type S3ManifestSource struct {
+	ManifestConfig
+	// The s3 client configured
+	client MinS3Api
+	// The ETag of the manifest the pending or applied retrievers came from
+	etag string
+	// The retrievers for the last manifest whose objects were all retrieved
+	applied []*S3ObjectRetriever
+	// The retrievers for a newer manifest whose objects have not all been retrieved yet
+	pending []*S3ObjectRetriever
+}
+
+// Creates a new manifest source that retrieves the objects listed in the manifest object
+func NewS3ManifestSource(client MinS3Api, config ManifestConfig) *S3ManifestSource {
+	if config.MaxConcurrentRequests <= 0 {
+		config.MaxConcurrentRequests = defaultMaxConcurrentRequests
+	}
+	return &S3ManifestSource{
+		ManifestConfig: config,
+		client:         client,
+	}
+}
+
+// Reads the manifest and, if a newer manifest's objects can all be retrieved as listed, switches to them.
+// Until then the objects of the last applied manifest are returned. An object that does not match its
+// ETag yet is not an error once a manifest has been applied, since its upload may still be in progress
+func (source *S3ManifestSource) Retrievers(ctx context.Context) ([]*S3ObjectRetriever, bool, error) {
+	if err := source.refreshManifest(ctx); err != nil {
+		log.Printf("unable to read manifest %s/%s: %v", source.Bucket, source.Key, err)
+		return source.applied, false, err
+	}
+	if source.pending == nil {
+		return source.applied, false, nil
+	}
+
+	results := retrieveConcurrently(ctx, source.pending, nil, source.MaxConcurrentRequests, source.RequestTimeout)
+	for _, result := range results {
+		if err := result.err; err != nil {
+			if errors.Is(err, ErrETagMismatch) && source.applied != nil {
+				log.Printf("not applying manifest %s/%s yet: %v", source.Bucket, source.Key, err)
+				return source.applied, false, nil
+			}
+			return source.applied, false, fmt.Errorf("unable to apply manifest %s/%s: %w", source.Bucket, source.Key, err)
+		}
+	}
+
+	log.Printf("applying manifest %s/%s with %d objects", source.Bucket, source.Key, len(source.pending))
+	source.applied, source.pending = source.pending, nil
+	return source.applied, true, nil
+}
+
+// Returns the retrievers of the last applied manifest
+func (source *S3ManifestSource) current() []*S3ObjectRetriever {
+	return source.applied
+}
+
+// Whether the events are for the manifest or for an object that a pending manifest is waiting on
+func (source *S3ManifestSource) affectedBy(events []S3ObjectEvent) bool {
+	if hasObjectEvent(events, source.Bucket, source.Key) {
+		return true
+	}
+	for _, retriever := range source.pending {
+		if retriever.affectedBy(events) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retrieves the manifest if it has changed and replaces the pending retrievers with the retrievers for its objects
+func (source *S3ManifestSource) refreshManifest(ctx context.Context) error {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(source.Bucket),
+		Key:    aws.String(source.Key),
+	}
+	if source.etag != "" {
+		input.IfNoneMatch = aws.String(source.etag)
+	}
+	if source.SSECustomerKey != nil {
+		if err := source.SSECustomerKey.apply(input); err != nil {
+			return err
+		}
+	}
+	output, err := source.client.GetObject(ctx, input)
+	if err != nil {
+		if isNotModified(err) {
+			return nil
+		}
+		return err
+	}
+	defer output.Body.Close()
+
+	etag := aws.ToString(output.ETag)
+	if etag != "" && etag == source.etag {
+		return nil
+	}
+
+	entries, err := readManifest(output.Body)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]*S3ObjectRetriever, 0, len(entries))
+	for idx, entry := range entries {
+		retriever, err := source.newRetriever(entry)
+		if err != nil {
+			return fmt.Errorf("objects[%d] %w", idx, err)
+		}
+		pending = append(pending, retriever)
+	}
+	source.pending = pending
+	source.etag = etag
+	return nil
+}
+
+// Creates the retriever for a manifest entry. The latest data retrieved for the same object is kept so that
+// an object that did not change is not downloaded again
+func (source *S3ManifestSource) newRetriever(entry manifestEntry) (*S3ObjectRetriever, error) {
+	bucket := entry.Bucket
+	if bucket == "" {
+		bucket = source.Bucket
+	}
+	var parser Parser
+	var err error
+	if entry.Parser != "" {
+		parser, err = ParseParser(entry.Parser)
+	} else {
+		parser, err = InferParser(entry.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	retriever := NewS3ObjectRetriever(source.client, RetrieverConfig{
+		Bucket:              bucket,
+		Key:                 entry.Key,
+		Parser:              parser,
+		Template:            source.Template,
+		MaxDecompressedSize: source.MaxDecompressedSize,
+		VersionID:           entry.VersionID,
+		ExpectedETag:        quoteETag(entry.ETag),
+		SSECustomerKey:      source.SSECustomerKey,
+		Decrypter:           source.Decrypter,
+		ObjectOptions:       source.ObjectOptions,
+	})
+	for _, previous := range append(append([]*S3ObjectRetriever{}, source.pending...), source.applied...) {
+		if previous.Bucket == bucket && previous.Key == entry.Key {
+			retriever.data = previous.data
+			break
+		}
+	}
+	return retriever, nil
+}
+
+// Reads a json or yaml manifest of at most maxManifestSize bytes
+func readManifest(body io.Reader) ([]manifestEntry, error) {
+	raw, err := io.ReadAll(io.LimitReader(body, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxManifestSize {
+		return nil, fmt.Errorf("manifest is larger than %d bytes", maxManifestSize)
+	}
+	return parseManifest(raw)
+}
+
+// Parses a json or yaml manifest
+func parseManifest(raw []byte) ([]manifestEntry, error) {
+	var parsed manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if len(parsed.Objects) == 0 {
+		return nil, errors.New("manifest lists no objects")
+	}
+	for idx, entry := range parsed.Objects {
+		if entry.Key == "" {
+			return nil, fmt.Errorf("manifest objects[%d] has no key", idx)
+		}
+		if entry.ETag == "" && entry.VersionID == "" {
+			return nil, fmt.Errorf("manifest objects[%d] %s needs an etag or a versionId", idx, entry.Key)
+		}
+	}
+	return parsed.Objects, nil
+}
+
+// Adds the quotes that s3 returns around ETags if they are missing
+func quoteETag(etag string) string {
+	etag = strings.Trim(strings.TrimSpace(etag), `"`)
+	if etag == "" {
+		return ""
+	}
+	return `"` + etag + `"`
+}
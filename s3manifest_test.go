@@ -0,0 +1,351 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newPreconditionFailedError() error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{
+			Response: &http.Response{StatusCode: http.StatusPreconditionFailed},
+		},
+		Err: errors.New("precondition failed"),
+	}
+}
+
+func objectOutput(etag string, body string) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		ETag: aws.String(etag),
+		Body: io.NopCloser(bytes.NewReader([]byte(body))),
+	}
+}
+
+func matchKey(key string) interface{} {
+	return mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == key
+	})
+}
+
+func TestParseManifest(t *testing.T) {
+	var tests = []struct {
+		name        string
+		raw         string
+		expected    []manifestEntry
+		expectedErr string
+	}{
+		{
+			"json",
+			`{"objects": [{"key": "routers.yaml", "etag": "\"abc\""}, {"bucket": "other", "key": "services", "versionId": "v1", "parser": "json"}]}`,
+			[]manifestEntry{
+				{Key: "routers.yaml", ETag: `"abc"`},
+				{Bucket: "other", Key: "services", VersionID: "v1", Parser: "json"},
+			},
+			"",
+		},
+		{
+			"yaml",
+			"objects:\n  - key: routers.yaml\n    etag: abc\n",
+			[]manifestEntry{{Key: "routers.yaml", ETag: "abc"}},
+			"",
+		},
+		{"no objects", `{"objects": []}`, nil, "manifest lists no objects"},
+		{"no key", `{"objects": [{"etag": "abc"}]}`, nil, "manifest objects[0] has no key"},
+		{"not pinned", `{"objects": [{"key": "routers.yaml"}]}`, nil, "manifest objects[0] routers.yaml needs an etag or a versionId"},
+		{"unknown field", `{"objects": [{"key": "routers.yaml", "eTag": "abc"}]}`, nil, "invalid manifest: yaml: unmarshal errors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseManifest([]byte(tt.raw))
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, entries)
+		})
+	}
+}
+
+func TestQuoteETag(t *testing.T) {
+	assert.Equal(t, `"abc"`, quoteETag("abc"))
+	assert.Equal(t, `"abc"`, quoteETag(` "abc" `))
+	assert.Equal(t, "", quoteETag(""))
+}
+
+func TestRetrieveExpectedETag(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket:       testBucket,
+		Key:          testKey,
+		Parser:       Json,
+		ExpectedETag: testETag,
+	})
+
+	// The object has not been uploaded yet
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return aws.ToString(arg.IfMatch) == testETag
+	}), mock.Anything).Return(nil, newPreconditionFailedError()).Once()
+	changed, err := retriever.Retrieve(ctx)
+	assert.ErrorIs(t, err, ErrETagMismatch)
+	assert.EqualError(t, err, "testbucket/testkey is not at ETag "+testETag+": object does not match its expected ETag")
+	assert.False(t, changed)
+
+	// A store that ignores If-Match
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(objectOutput(`"other"`, testJson), nil).Once()
+	changed, err = retriever.Retrieve(ctx)
+	assert.ErrorIs(t, err, ErrETagMismatch)
+	assert.False(t, changed)
+	assert.Nil(t, retriever.data)
+
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(objectOutput(testETag, testJson), nil).Once()
+	changed, err = retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	// Once retrieved at its ETag the object is not requested again
+	changed, err = retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	mockClient.AssertNumberOfCalls(t, "GetObject", 3)
+}
+
+func TestRetrieveExpectedETagWithPreviousData(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket:       testBucket,
+		Key:          testKey,
+		Parser:       Json,
+		ExpectedETag: testETag,
+	})
+	// Data kept from the object at a previous manifest
+	previous := &ConfigData{json: map[string]interface{}{"old": true}, etag: `"old"`}
+	retriever.data = previous
+
+	// A store that answers If-None-Match even though only If-Match is sent
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return aws.ToString(arg.IfMatch) == testETag && arg.IfNoneMatch == nil
+	}), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	changed, err := retriever.Retrieve(ctx)
+	assert.ErrorIs(t, err, ErrETagMismatch)
+	assert.False(t, changed)
+	assert.Same(t, previous, retriever.data)
+
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(objectOutput(testETag, testJson), nil).Once()
+	changed, err = retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, testJsonMap, retriever.data.json)
+	mockClient.AssertExpectations(t)
+}
+
+func TestManifestSourceAppliesCompleteSets(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	source := NewS3ManifestSource(mockClient, ManifestConfig{
+		Bucket: testBucket,
+		Key:    "release.json",
+	})
+
+	// The first manifest and its objects
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(objectOutput("m1",
+		`{"objects": [{"key": "routers.yaml", "etag": "r1"}, {"key": "services.json", "versionId": "s1"}]}`), nil).Once()
+	mockClient.On("GetObject", ctx, matchKey("routers.yaml"), mock.Anything).Return(objectOutput(`"r1"`, "value: 1"), nil).Once()
+	mockClient.On("GetObject", ctx, matchGet("services.json", "s1"), mock.Anything).Return(objectOutput(`"s1"`, `{"other": 1}`), nil).Once()
+	retrievers, changed, err := source.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	require.Len(t, retrievers, 2)
+	assert.Equal(t, map[string]interface{}{"value": float64(1)}, retrievers[0].data.json)
+	assert.Equal(t, `"r1"`, retrievers[0].ExpectedETag)
+	assert.Equal(t, "s1", retrievers[1].VersionID)
+
+	// An unchanged manifest keeps the same retrievers
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "release.json" && aws.ToString(arg.IfNoneMatch) == "m1"
+	}), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	unchanged, changed, err := source.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, retrievers, unchanged)
+
+	// A new manifest is uploaded before routers.yaml
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(objectOutput("m2",
+		`{"objects": [{"key": "routers.yaml", "etag": "r2"}, {"key": "services.json", "versionId": "s2"}]}`), nil).Once()
+	mockClient.On("GetObject", ctx, matchKey("routers.yaml"), mock.Anything).Return(nil, newPreconditionFailedError()).Once()
+	mockClient.On("GetObject", ctx, matchGet("services.json", "s2"), mock.Anything).Return(objectOutput(`"s2"`, `{"other": 2}`), nil).Once()
+	waiting, changed, err := source.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, retrievers, waiting, "the applied objects are kept")
+	assert.Equal(t, retrievers, source.current())
+	assert.True(t, source.affectedBy([]S3ObjectEvent{{Bucket: testBucket, Key: "routers.yaml"}}), "the pending objects are watched")
+	assert.False(t, source.affectedBy([]S3ObjectEvent{{Bucket: testBucket, Key: "unlisted.yaml"}}))
+
+	// routers.yaml is uploaded
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "routers.yaml" && aws.ToString(arg.IfMatch) == `"r2"` && arg.IfNoneMatch == nil
+	}), mock.Anything).Return(objectOutput(`"r2"`, "value: 2"), nil).Once()
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "services.json" && aws.ToString(arg.IfNoneMatch) == `"s2"`
+	}), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	applied, changed, err := source.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	require.Len(t, applied, 2)
+	assert.Equal(t, map[string]interface{}{"value": float64(2)}, applied[0].data.json)
+	assert.Equal(t, map[string]interface{}{"other": float64(2)}, applied[1].data.json)
+	mockClient.AssertExpectations(t)
+}
+
+func TestManifestSourceErrors(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	source := NewS3ManifestSource(mockClient, ManifestConfig{
+		Bucket: testBucket,
+		Key:    "release.json",
+	})
+
+	// Nothing has been applied, so a mismatch is an error
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(objectOutput("m1",
+		`{"objects": [{"key": "routers.yaml", "etag": "r1"}]}`), nil).Once()
+	mockClient.On("GetObject", ctx, matchKey("routers.yaml"), mock.Anything).Return(nil, newPreconditionFailedError()).Once()
+	retrievers, changed, err := source.Retrievers(ctx)
+	assert.ErrorIs(t, err, ErrETagMismatch)
+	assert.ErrorContains(t, err, "unable to apply manifest testbucket/release.json")
+	assert.False(t, changed)
+	assert.Empty(t, retrievers)
+
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(objectOutput("m2",
+		`{"objects": [{"key": "routers", "etag": "r1"}]}`), nil).Once()
+	_, _, err = source.Retrievers(ctx)
+	assert.ErrorContains(t, err, "objects[0] cannot infer parser for key routers")
+
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(nil, errors.New("Oh no!")).Once()
+	_, _, err = source.Retrievers(ctx)
+	assert.EqualError(t, err, "Oh no!")
+}
+
+func TestManifestSourceRequestLimits(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	source := NewS3ManifestSource(mockClient, ManifestConfig{
+		Bucket:                testBucket,
+		Key:                   "release.json",
+		MaxConcurrentRequests: 2,
+		RequestTimeout:        50 * time.Millisecond,
+	})
+
+	mockClient.On("GetObject", ctx, matchKey("release.json"), mock.Anything).Return(objectOutput("m1",
+		`{"objects": [{"key": "a.json", "etag": "a"}, {"key": "b.json", "etag": "b"}, {"key": "c.json", "etag": "c"}]}`), nil).Once()
+	var inFlight, maxInFlight int32
+	mockClient.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		current := atomic.AddInt32(&inFlight, 1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		// Every listed object hangs until its request times out
+		<-args.Get(0).(context.Context).Done()
+		atomic.AddInt32(&inFlight, -1)
+	}).Return(nil, context.DeadlineExceeded)
+
+	start := time.Now()
+	_, _, err := source.Retrievers(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+	mockClient.AssertNumberOfCalls(t, "GetObject", 4)
+}
+
+func TestManifestProvider(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [
+		{
+			"manifest": "release.yaml",
+			"bucket": "someBucket"
+		}
+	]}`), &config)
+	require.NoError(t, err)
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	mockClient := newMockS3Client()
+	provider.sources[0].(*S3ManifestSource).client = mockClient
+
+	ctx := context.Background()
+	manifest := func(routers string, services string) string {
+		return "objects:\n  - key: routers.json\n    etag: " + routers + "\n  - key: services.json\n    etag: " + services + "\n"
+	}
+	mockClient.On("GetObject", mock.Anything, matchKey("release.yaml"), mock.Anything).Return(objectOutput("m1", manifest("r1", "s1")), nil).Once()
+	mockClient.On("GetObject", mock.Anything, matchKey("routers.json"), mock.Anything).Return(objectOutput(`"r1"`,
+		`{"http": {"routers": {"api": {"service": "api"}}}}`), nil).Once()
+	mockClient.On("GetObject", mock.Anything, matchKey("services.json"), mock.Anything).Return(objectOutput(`"s1"`,
+		`{"http": {"services": {"api": {"loadBalancer": {}}}}}`), nil).Once()
+
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"http": {"routers": {"api": {"service": "api"}}, "services": {"api": {"loadBalancer": {}}}}}`, string(received))
+
+	// The routers have been uploaded but not the services they point at
+	mockClient.On("GetObject", mock.Anything, matchKey("release.yaml"), mock.Anything).Return(objectOutput("m2", manifest("r2", "s2")), nil).Once()
+	mockClient.On("GetObject", mock.Anything, matchKey("routers.json"), mock.Anything).Return(objectOutput(`"r2"`,
+		`{"http": {"routers": {"web": {"service": "web"}}}}`), nil).Once()
+	mockClient.On("GetObject", mock.Anything, matchKey("services.json"), mock.Anything).Return(nil, newPreconditionFailedError()).Once()
+
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.Nil(t, received, "nothing is emitted for a partial set")
+
+	mockClient.On("GetObject", mock.Anything, matchKey("release.yaml"), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	mockClient.On("GetObject", mock.Anything, matchKey("services.json"), mock.Anything).Return(objectOutput(`"s2"`,
+		`{"http": {"services": {"web": {"loadBalancer": {}}}}}`), nil).Once()
+
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"http": {"routers": {"web": {"service": "web"}}, "services": {"web": {"loadBalancer": {}}}}}`, string(received))
+	mockClient.AssertExpectations(t)
+}
+
+func TestNewManifestValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		object        string
+		expectedError string
+	}{
+		{"with key", `"manifest": "release.json", "key": "huh.json"`, "object[0] cannot have a manifest with a key or a prefix"},
+		{"with prefix", `"manifest": "release.json", "prefix": "dynamic/"`, "object[0] cannot have a manifest with a key or a prefix"},
+		{"with version", `"manifest": "release.json", "versionId": "v1"`, "object[0] cannot be optional or set a version or parser with a manifest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [{"bucket": "someBucket", `+tt.object+`}]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package s3provider
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"dario.cat/mergo"
+)
+
+// How an object's configuration is merged into the configuration of the objects before it
+type MergeStrategy string
+
+const (
+	// Earlier values are kept and slices are appended. The default
+	MergeAppend MergeStrategy = "append"
+	// Later values override earlier ones, including whole slices
+	MergeReplace MergeStrategy = "replace"
+	// Later values override earlier ones and slices are appended
+	MergeOverride MergeStrategy = "override-later-wins"
+	// Later values override earlier ones and slices are appended, skipping elements that are already in the slice
+	MergeDedupe MergeStrategy = "deep-merge-with-slice-dedupe"
+)
+
+var ValidMergeStrategies = []MergeStrategy{MergeAppend, MergeReplace, MergeOverride, MergeDedupe}
+
+// Parses a merge strategy name. An empty name is the default append strategy
+func ParseMergeStrategy(s string) (MergeStrategy, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return MergeAppend, nil
+	}
+	for _, strategy := range ValidMergeStrategies {
+		if s == string(strategy) {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a valid merge strategy", s)
+}
+
+// Merges src into dst with the strategy. src must not be used afterwards since its nested maps may end up in dst
+func mergeJson(dst *map[string]interface{}, src map[string]interface{}, strategy MergeStrategy) error {
+	switch strategy {
+	case MergeReplace:
+		return mergo.Merge(dst, src, mergo.WithOverride)
+	case MergeOverride:
+		return mergo.Merge(dst, src, mergo.WithOverride, mergo.WithAppendSlice)
+	case MergeDedupe:
+		removeDuplicates(*dst, src)
+		return mergo.Merge(dst, src, mergo.WithOverride, mergo.WithAppendSlice)
+	default:
+		return mergo.Merge(dst, src, mergo.WithAppendSlice)
+	}
+}
+
+// Removes the elements of each slice in src that are already in the slice at the same path in dst, along with
+// repeated elements within the src slice
+func removeDuplicates(dst map[string]interface{}, src map[string]interface{}) {
+	for key, srcValue := range src {
+		dstValue := dst[key]
+		switch s := srcValue.(type) {
+		case map[string]interface{}:
+			if d, ok := dstValue.(map[string]interface{}); ok {
+				removeDuplicates(d, s)
+			}
+		case []interface{}:
+			existing, _ := dstValue.([]interface{})
+			var unique []interface{}
+			for _, el := range s {
+				if !containsJson(existing, el) && !containsJson(unique, el) {
+					unique = append(unique, el)
+				}
+			}
+			src[key] = unique
+		}
+	}
+}
+
+func containsJson(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package s3provider
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mergeEarlier = `{
+	"http": {
+		"routers": {
+			"api": {"rule": "Host(` + "`a.com`" + `)", "service": "api", "middlewares": ["auth"]}
+		}
+	},
+	"tls": {
+		"certificates": [
+			{"certFile": "a.cert", "keyFile": "a.key"}
+		]
+	}
+}`
+	mergeLater = `{
+	"http": {
+		"routers": {
+			"api": {"rule": "Host(` + "`b.com`" + `)", "middlewares": ["auth", "ratelimit"]},
+			"web": {"rule": "Host(` + "`web.com`" + `)", "service": "web"}
+		}
+	},
+	"tls": {
+		"certificates": [
+			{"certFile": "a.cert", "keyFile": "a.key"},
+			{"certFile": "b.cert", "keyFile": "b.key"},
+			{"certFile": "b.cert", "keyFile": "b.key"}
+		]
+	}
+}`
+)
+
+func TestParseMergeStrategy(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected MergeStrategy
+	}{
+		{"", MergeAppend},
+		{"append", MergeAppend},
+		{"Replace", MergeReplace},
+		{" override-later-wins ", MergeOverride},
+		{"deep-merge-with-slice-dedupe", MergeDedupe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			strategy, err := ParseMergeStrategy(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, strategy)
+		})
+	}
+
+	_, err := ParseMergeStrategy("union")
+	assert.ErrorContains(t, err, `"union" is not a valid merge strategy`)
+}
+
+func TestMergeStrategies(t *testing.T) {
+	var tests = []struct {
+		strategy MergeStrategy
+		expected string
+	}{
+		{MergeAppend, `{
+			"http": {"routers": {
+				"api": {"rule": "Host(` + "`a.com`" + `)", "service": "api", "middlewares": ["auth", "auth", "ratelimit"]},
+				"web": {"rule": "Host(` + "`web.com`" + `)", "service": "web"}
+			}},
+			"tls": {"certificates": [
+				{"certFile": "a.cert", "keyFile": "a.key"},
+				{"certFile": "a.cert", "keyFile": "a.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"}
+			]}
+		}`},
+		{MergeReplace, `{
+			"http": {"routers": {
+				"api": {"rule": "Host(` + "`b.com`" + `)", "service": "api", "middlewares": ["auth", "ratelimit"]},
+				"web": {"rule": "Host(` + "`web.com`" + `)", "service": "web"}
+			}},
+			"tls": {"certificates": [
+				{"certFile": "a.cert", "keyFile": "a.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"}
+			]}
+		}`},
+		{MergeOverride, `{
+			"http": {"routers": {
+				"api": {"rule": "Host(` + "`b.com`" + `)", "service": "api", "middlewares": ["auth", "auth", "ratelimit"]},
+				"web": {"rule": "Host(` + "`web.com`" + `)", "service": "web"}
+			}},
+			"tls": {"certificates": [
+				{"certFile": "a.cert", "keyFile": "a.key"},
+				{"certFile": "a.cert", "keyFile": "a.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"}
+			]}
+		}`},
+		{MergeDedupe, `{
+			"http": {"routers": {
+				"api": {"rule": "Host(` + "`b.com`" + `)", "service": "api", "middlewares": ["auth", "ratelimit"]},
+				"web": {"rule": "Host(` + "`web.com`" + `)", "service": "web"}
+			}},
+			"tls": {"certificates": [
+				{"certFile": "a.cert", "keyFile": "a.key"},
+				{"certFile": "b.cert", "keyFile": "b.key"}
+			]}
+		}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			var earlier, later, expected map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(mergeEarlier), &earlier))
+			require.NoError(t, json.Unmarshal([]byte(mergeLater), &later))
+			require.NoError(t, json.Unmarshal([]byte(tt.expected), &expected))
+
+			composite := make(map[string]interface{})
+			require.NoError(t, mergeJson(&composite, earlier, MergeAppend))
+			require.NoError(t, mergeJson(&composite, later, tt.strategy))
+			assert.Equal(t, expected, composite)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package s3provider
+
+import "strings"
+
+// Prefixes the name of every router, service and middleware defined in data, along with the references to them
+// from routers, services and middlewares in data. References to names that data does not define, including
+// references to other providers (name@provider), are left alone.
+func prefixNames(data map[string]interface{}, prefix string) {
+	for _, protocol := range []string{"http", "tcp", "udp"} {
+		section, ok := data[protocol].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Routers are not referenced by name, but are still prefixed so that they cannot clash
+		prefixKeys(section, "routers", prefix)
+		services := prefixKeys(section, "services", prefix)
+		middlewares := prefixKeys(section, "middlewares", prefix)
+
+		for _, router := range entries(section, "routers") {
+			prefixReference(router, "service", services, prefix)
+			prefixReferences(router, "middlewares", middlewares, prefix)
+		}
+		for _, service := range entries(section, "services") {
+			if weighted, ok := service["weighted"].(map[string]interface{}); ok {
+				prefixChildren(weighted, "services", services, prefix)
+			}
+			if mirroring, ok := service["mirroring"].(map[string]interface{}); ok {
+				prefixReference(mirroring, "service", services, prefix)
+				prefixChildren(mirroring, "mirrors", services, prefix)
+			}
+			if failover, ok := service["failover"].(map[string]interface{}); ok {
+				prefixReference(failover, "service", services, prefix)
+				prefixReference(failover, "fallback", services, prefix)
+			}
+		}
+		for _, middleware := range entries(section, "middlewares") {
+			if chain, ok := middleware["chain"].(map[string]interface{}); ok {
+				prefixReferences(chain, "middlewares", middlewares, prefix)
+			}
+			if errorPages, ok := middleware["errors"].(map[string]interface{}); ok {
+				prefixReference(errorPages, "service", services, prefix)
+			}
+		}
+	}
+}
+
+// Prefixes the names of the entries of section[key] and returns the original names
+func prefixKeys(section map[string]interface{}, key string, prefix string) map[string]bool {
+	named, ok := section[key].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	names := make(map[string]bool, len(named))
+	prefixed := make(map[string]interface{}, len(named))
+	for name, value := range named {
+		names[name] = true
+		prefixed[prefix+name] = value
+	}
+	section[key] = prefixed
+	return names
+}
+
+// Returns the entries of section[key] that are objects
+func entries(section map[string]interface{}, key string) []map[string]interface{} {
+	named, _ := section[key].(map[string]interface{})
+	var values []map[string]interface{}
+	for _, value := range named {
+		if m, ok := value.(map[string]interface{}); ok {
+			values = append(values, m)
+		}
+	}
+	return values
+}
+
+func prefixName(name string, defined map[string]bool, prefix string) string {
+	if strings.Contains(name, "@") || !defined[name] {
+		return name
+	}
+	return prefix + name
+}
+
+// Prefixes the name in parent[key]
+func prefixReference(parent map[string]interface{}, key string, defined map[string]bool, prefix string) {
+	if name, ok := parent[key].(string); ok {
+		parent[key] = prefixName(name, defined, prefix)
+	}
+}
+
+// Prefixes each name in the list parent[key]
+func prefixReferences(parent map[string]interface{}, key string, defined map[string]bool, prefix string) {
+	names, _ := parent[key].([]interface{})
+	for i, value := range names {
+		if name, ok := value.(string); ok {
+			names[i] = prefixName(name, defined, prefix)
+		}
+	}
+}
+
+// Prefixes the name field of each object in the list parent[key]
+func prefixChildren(parent map[string]interface{}, key string, defined map[string]bool, prefix string) {
+	children, _ := parent[key].([]interface{})
+	for _, child := range children {
+		if m, ok := child.(map[string]interface{}); ok {
+			prefixReference(m, "name", defined, prefix)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package s3provider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixNames(t *testing.T) {
+	var tests = []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			"http routers and references",
+			`{"http": {
+				"routers": {"api": {"service": "api", "middlewares": ["auth", "shared", "global@file"]}},
+				"services": {"api": {"loadBalancer": {"servers": [{"url": "http://a"}]}}},
+				"middlewares": {"auth": {"basicAuth": {"users": ["a:b"]}}}
+			}}`,
+			`{"http": {
+				"routers": {"team-api": {"service": "team-api", "middlewares": ["team-auth", "shared", "global@file"]}},
+				"services": {"team-api": {"loadBalancer": {"servers": [{"url": "http://a"}]}}},
+				"middlewares": {"team-auth": {"basicAuth": {"users": ["a:b"]}}}
+			}}`,
+		},
+		{
+			"http service children",
+			`{"http": {"services": {
+				"blue": {"loadBalancer": {}},
+				"green": {"loadBalancer": {}},
+				"split": {"weighted": {"services": [{"name": "blue", "weight": 1}, {"name": "other@docker", "weight": 1}]}},
+				"mirror": {"mirroring": {"service": "blue", "mirrors": [{"name": "green", "percent": 10}]}},
+				"backup": {"failover": {"service": "blue", "fallback": "green"}}
+			}}}`,
+			`{"http": {"services": {
+				"team-blue": {"loadBalancer": {}},
+				"team-green": {"loadBalancer": {}},
+				"team-split": {"weighted": {"services": [{"name": "team-blue", "weight": 1}, {"name": "other@docker", "weight": 1}]}},
+				"team-mirror": {"mirroring": {"service": "team-blue", "mirrors": [{"name": "team-green", "percent": 10}]}},
+				"team-backup": {"failover": {"service": "team-blue", "fallback": "team-green"}}
+			}}}`,
+		},
+		{
+			"http middleware references",
+			`{"http": {
+				"services": {"pages": {"loadBalancer": {}}},
+				"middlewares": {
+					"auth": {"basicAuth": {}},
+					"secured": {"chain": {"middlewares": ["auth", "platform-headers"]}},
+					"oops": {"errors": {"status": ["500-599"], "service": "pages", "query": "/{status}.html"}}
+				}
+			}}`,
+			`{"http": {
+				"services": {"team-pages": {"loadBalancer": {}}},
+				"middlewares": {
+					"team-auth": {"basicAuth": {}},
+					"team-secured": {"chain": {"middlewares": ["team-auth", "platform-headers"]}},
+					"team-oops": {"errors": {"status": ["500-599"], "service": "team-pages", "query": "/{status}.html"}}
+				}
+			}}`,
+		},
+		{
+			"tcp and udp",
+			`{
+				"tcp": {
+					"routers": {"db": {"service": "db", "middlewares": ["allow"]}},
+					"services": {"db": {"loadBalancer": {}}, "dbs": {"weighted": {"services": [{"name": "db", "weight": 1}]}}},
+					"middlewares": {"allow": {"ipAllowList": {"sourceRange": ["10.0.0.0/8"]}}}
+				},
+				"udp": {
+					"routers": {"dns": {"service": "dns"}},
+					"services": {"dns": {"loadBalancer": {}}}
+				}
+			}`,
+			`{
+				"tcp": {
+					"routers": {"team-db": {"service": "team-db", "middlewares": ["team-allow"]}},
+					"services": {"team-db": {"loadBalancer": {}}, "team-dbs": {"weighted": {"services": [{"name": "team-db", "weight": 1}]}}},
+					"middlewares": {"team-allow": {"ipAllowList": {"sourceRange": ["10.0.0.0/8"]}}}
+				},
+				"udp": {
+					"routers": {"team-dns": {"service": "team-dns"}},
+					"services": {"team-dns": {"loadBalancer": {}}}
+				}
+			}`,
+		},
+		{
+			"other sections are untouched",
+			`{"http": {"serversTransports": {"internal": {}}}, "tls": {"options": {"modern": {}}}}`,
+			`{"http": {"serversTransports": {"internal": {}}}, "tls": {"options": {"modern": {}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mustUnmarshal(t, tt.data)
+			prefixNames(data, "team-")
+			assert.Equal(t, mustUnmarshal(t, tt.expected), data)
+		})
+	}
+}
+
+func TestMergePrefixedObjectsDoNotConflict(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "conflictPolicy": "fail", "objects": [
+		{
+			"key": "first.json",
+			"bucket": "someBucket",
+			"namePrefix": "first-"
+		},
+		{
+			"key": "second.json",
+			"bucket": "otherBucket",
+			"namePrefix": "second-"
+		}
+	]}`), &config)
+	require.NoError(t, err)
+
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	provider.retrievers[0].data = &ConfigData{json: mustUnmarshal(t, conflictFirst)}
+	provider.retrievers[1].data = &ConfigData{json: mustUnmarshal(t, conflictSecond)}
+
+	composite, err := provider.merge()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"service": "first-api",
+		"rule":    "Host(`a.com`)",
+	}, lookupJson(composite, []string{"http", "routers", "first-api"}))
+	assert.Equal(t, map[string]interface{}{
+		"middlewares": []interface{}{"second-auth"},
+		"rule":        "Host(`b.com`)",
+	}, lookupJson(composite, []string{"http", "routers", "second-api"}))
+	// The stored data is not prefixed
+	assert.Contains(t, provider.retrievers[0].data.json["http"].(map[string]interface{})["routers"], "api")
+}
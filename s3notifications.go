@@ -0,0 +1,227 @@
+package s3provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// The longest an sqs long poll can wait for messages
+const maxNotificationWaitTime = 20 * time.Second
+
+// How long to wait before receiving again after the queue could not be read
+const notificationRetryInterval = 5 * time.Second
+
+// The sqs api needed to receive s3 event notifications
+type MinSQSApi interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+type NotificationConfig struct {
+	// The url of the sqs queue that the buckets send s3 event notifications to
+	QueueURL string `json:"queueUrl"`
+	// The name of an entry in Config.Connections whose region and credentials are used for the queue.
+	// Uses the default connection if empty
+	Connection string `json:"connection,omitempty"`
+	// Overrides the sqs endpoint. The connection's endpoint only applies to s3
+	Endpoint string `json:"endpoint,omitempty"`
+	// A Golang duration string for how long each receive waits for messages. Defaults to, and cannot exceed, 20s
+	WaitTime string `json:"waitTime,omitempty"`
+}
+
+// A created or removed object from an s3 event notification
+type S3ObjectEvent struct {
+	Bucket string
+	Key    string
+}
+
+// The parts of an s3 event notification that we use
+type s3EventMessage struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+	// Set when the bucket notifies an sns topic that the queue is subscribed to
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// Parses the ObjectCreated and ObjectRemoved events from an sqs message body. Other events, like the
+// s3:TestEvent sent when notifications are configured, are ignored
+func ParseS3Events(body string) ([]S3ObjectEvent, error) {
+	var message s3EventMessage
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		return nil, err
+	}
+	if message.Type == "Notification" && message.Message != "" {
+		return ParseS3Events(message.Message)
+	}
+
+	var events []S3ObjectEvent
+	for _, record := range message.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") && !strings.HasPrefix(record.EventName, "ObjectRemoved:") {
+			continue
+		}
+		// Keys are url encoded in event notifications
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", record.S3.Object.Key, err)
+		}
+		events = append(events, S3ObjectEvent{
+			Bucket: record.S3.Bucket.Name,
+			Key:    key,
+		})
+	}
+	return events, nil
+}
+
+// Long polls an sqs queue for s3 event notifications
+type S3EventListener struct {
+	// The sqs client configured
+	client MinSQSApi
+	// The queue to receive from
+	queueURL string
+	// How long each receive waits for messages
+	waitTime time.Duration
+	// What the listener waits on after a failed receive
+	clock Clock
+}
+
+// Creates a listener for the queue. A waitTime of 0 uses the longest allowed long poll
+func NewS3EventListener(client MinSQSApi, queueURL string, waitTime time.Duration) *S3EventListener {
+	if waitTime == 0 {
+		waitTime = maxNotificationWaitTime
+	}
+	return &S3EventListener{
+		client:   client,
+		queueURL: queueURL,
+		waitTime: waitTime,
+		clock:    realClock{},
+	}
+}
+
+// Receives messages until ctx is done and sends the object events of each batch of messages to events.
+// Messages are deleted once their events are sent. Messages that are not s3 event notifications are logged and deleted
+func (listener *S3EventListener) Listen(ctx context.Context, events chan<- []S3ObjectEvent) {
+	for ctx.Err() == nil {
+		out, err := listener.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(listener.queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     int32(listener.waitTime / time.Second),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("unable to receive notifications from %s: %v", listener.queueURL, err)
+			select {
+			case <-listener.clock.After(notificationRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		if batch := parseMessages(out.Messages); len(batch) > 0 {
+			select {
+			case events <- batch:
+			case <-ctx.Done():
+				return
+			}
+		}
+		listener.deleteMessages(ctx, out.Messages)
+	}
+}
+
+// Returns the object events of every message that is an s3 event notification
+func parseMessages(messages []types.Message) []S3ObjectEvent {
+	var batch []S3ObjectEvent
+	for _, message := range messages {
+		parsed, err := ParseS3Events(aws.ToString(message.Body))
+		if err != nil {
+			log.Printf("ignoring unrecognized notification %s: %v", aws.ToString(message.MessageId), err)
+			continue
+		}
+		batch = append(batch, parsed...)
+	}
+	return batch
+}
+
+func (listener *S3EventListener) deleteMessages(ctx context.Context, messages []types.Message) {
+	for _, message := range messages {
+		_, err := listener.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(listener.queueURL),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("unable to delete notification %s: %v", aws.ToString(message.MessageId), err)
+		}
+	}
+}
+
+// Creates an sqs client from the connection, with an optional endpoint override
+func NewSQSClient(ctx context.Context, clientConfig ClientConfig, endpoint string) (*sqs.Client, error) {
+	cfg, err := loadAwsConfig(ctx, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := sqs.NewFromConfig(cfg, func(opts *sqs.Options) {
+		if endpoint != "" {
+			opts.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	return client, nil
+}
+
+// Validates the notification settings and creates a listener with the connection's credentials
+func newNotificationListener(ctx context.Context, config NotificationConfig, connection ClientConfig) (*S3EventListener, error) {
+	if config.QueueURL == "" {
+		return nil, errors.New("notifications must have a queueUrl")
+	}
+	var waitTime time.Duration
+	if config.WaitTime != "" {
+		var err error
+		waitTime, err = time.ParseDuration(config.WaitTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification wait time: %w", err)
+		}
+		if waitTime < time.Second || waitTime > maxNotificationWaitTime {
+			return nil, fmt.Errorf("notification wait time must be between 1s and %s", maxNotificationWaitTime)
+		}
+	}
+
+	client, err := NewSQSClient(ctx, connection, config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3EventListener(client, config.QueueURL, waitTime), nil
+}
+
+// Whether any of the events are for the bucket and key
+func hasObjectEvent(events []S3ObjectEvent, bucket string, key string) bool {
+	for _, event := range events {
+		if event.Bucket == bucket && event.Key == key {
+			return true
+		}
+	}
+	return false
+}
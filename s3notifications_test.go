@@ -0,0 +1,307 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/config-events"
+
+// A stand in sqs endpoint (json protocol) that hands out queued messages and records deletes
+type stubSqs struct {
+	mu sync.Mutex
+	// The bodies of messages that have not been received yet
+	pending []string
+	// The number of messages sent so far, used for ids and receipt handles
+	sent int
+	// The receipt handles of deleted messages
+	deleted []string
+	// The endpoint url
+	url string
+}
+
+func newStubSqs(t *testing.T) *stubSqs {
+	t.Helper()
+	stub := &stubSqs{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			ReceiptHandle string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			messages := stub.receive()
+			if len(messages) == 0 {
+				// Keep the listener from spinning while the queue is empty
+				time.Sleep(10 * time.Millisecond)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Messages": messages})
+		case "AmazonSQS.DeleteMessage":
+			stub.mu.Lock()
+			stub.deleted = append(stub.deleted, input.ReceiptHandle)
+			stub.mu.Unlock()
+			fmt.Fprint(w, "{}")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	stub.url = server.URL
+	return stub
+}
+
+func (stub *stubSqs) send(body string) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.pending = append(stub.pending, body)
+}
+
+func (stub *stubSqs) receive() []map[string]string {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	var messages []map[string]string
+	for _, body := range stub.pending {
+		stub.sent++
+		sum := md5.Sum([]byte(body))
+		messages = append(messages, map[string]string{
+			"MessageId":     fmt.Sprintf("message%d", stub.sent),
+			"ReceiptHandle": fmt.Sprintf("receipt%d", stub.sent),
+			"Body":          body,
+			"MD5OfBody":     hex.EncodeToString(sum[:]),
+		})
+	}
+	stub.pending = nil
+	return messages
+}
+
+func (stub *stubSqs) deletes() []string {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return append([]string{}, stub.deleted...)
+}
+
+func newS3EventBody(eventName string, bucket string, key string) string {
+	return fmt.Sprintf(`{"Records":[{"eventVersion":"2.1","eventSource":"aws:s3","eventName":"%s","s3":{"bucket":{"name":"%s"},"object":{"key":"%s","size":10}}}]}`, eventName, bucket, key)
+}
+
+func TestParseS3Events(t *testing.T) {
+	snsBody, _ := json.Marshal(map[string]string{
+		"Type":    "Notification",
+		"Message": newS3EventBody("ObjectRemoved:Delete", "someBucket", "dynamic/a.json"),
+	})
+
+	var tests = []struct {
+		name     string
+		body     string
+		expected []S3ObjectEvent
+	}{
+		{"created", newS3EventBody("ObjectCreated:Put", "someBucket", "huh.json"), []S3ObjectEvent{{"someBucket", "huh.json"}}},
+		{"removed", newS3EventBody("ObjectRemoved:Delete", "someBucket", "huh.json"), []S3ObjectEvent{{"someBucket", "huh.json"}}},
+		{"url encoded key", newS3EventBody("ObjectCreated:Put", "someBucket", "my+configs/a%3Db.yaml"), []S3ObjectEvent{{"someBucket", "my configs/a=b.yaml"}}},
+		{"sns wrapped", string(snsBody), []S3ObjectEvent{{"someBucket", "dynamic/a.json"}}},
+		{"other event", newS3EventBody("ObjectRestore:Completed", "someBucket", "huh.json"), nil},
+		{"test event", `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"someBucket"}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := ParseS3Events(tt.body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, events)
+		})
+	}
+}
+
+func TestParseS3EventsInvalid(t *testing.T) {
+	_, err := ParseS3Events("not json")
+	assert.Error(t, err)
+}
+
+func TestListenSendsEventsAndDeletes(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-1")
+	stub := newStubSqs(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	client, err := NewSQSClient(ctx, ClientConfig{
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}, stub.url)
+	require.NoError(t, err)
+	listener := NewS3EventListener(client, testQueueURL, time.Second)
+
+	stub.send(newS3EventBody("ObjectCreated:Put", "someBucket", "huh.json"))
+	stub.send("garbage")
+	events := make(chan []S3ObjectEvent)
+	go listener.Listen(ctx, events)
+
+	select {
+	case batch := <-events:
+		assert.Equal(t, []S3ObjectEvent{{"someBucket", "huh.json"}}, batch)
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "timed out waiting for events")
+	}
+
+	// Both the handled and the unrecognized message are deleted
+	require.Eventually(t, func() bool {
+		return len(stub.deletes()) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"receipt1", "receipt2"}, stub.deletes())
+}
+
+func TestNewNotificationsValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		notifications string
+		expectedError string
+	}{
+		{"missing queue", `{}`, "notifications must have a queueUrl"},
+		{"unknown connection", `{"queueUrl": "` + testQueueURL + `", "connection": "other"}`, "notifications reference unknown connection other"},
+		{"bad wait time", `{"queueUrl": "` + testQueueURL + `", "waitTime": "5"}`, "invalid notification wait time"},
+		{"long wait time", `{"queueUrl": "` + testQueueURL + `", "waitTime": "1m"}`, "notification wait time must be between 1s and 20s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", "notifications": `+tt.notifications+`, "objects": [
+				{
+					"key": "huh.json",
+					"bucket": "someBucket"
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+// The settings for notifications from the sqs endpoint
+func notificationSettings(sqsEndpoint string) string {
+	return `"accessKeyId": "key",
+		"secretAccessKey": "secret",
+		"notifications": {
+			"queueUrl": "` + testQueueURL + `",
+			"endpoint": "` + sqsEndpoint + `",
+			"waitTime": "1s"
+		}`
+}
+
+// The objects that notifications are tested with
+var notifiedObjects = []string{`{"key": "huh.json", "bucket": "someBucket"}`, `{"prefix": "dynamic/", "bucket": "someBucket"}`}
+
+func TestNotificationRefreshesOnlyNotifiedObjects(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("AWS_REGION", "us-east-1")
+	provider, s3Client := newTestProvider(t, notificationSettings("http://localhost"), notifiedObjects...)
+	now := time.Now()
+	s3Client.On("ListObjectsV2", mock.Anything, mock.Anything, mock.Anything).Return(newListPage(), nil).Once()
+	s3Client.On("ListObjectsV2", mock.Anything, mock.Anything, mock.Anything).Return(newListPage("dynamic/a.yml"), nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "huh.json"
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("json1"),
+		Body:         io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "dynamic/a.yml"
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("yaml1"),
+		Body:         io.NopCloser(bytes.NewReader([]byte(yaml1))),
+	}, nil).Once()
+
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ := json.Marshal(mustUnmarshal(t, json1))
+	assert.Equal(t, string(expBytes), string(received))
+
+	// An unrelated object does not contact s3
+	received, err = provider.getConfiguration(ctx, []S3ObjectEvent{{"someBucket", "other.json"}, {"otherBucket", "huh.json"}})
+	require.NoError(t, err)
+	assert.Nil(t, received)
+	s3Client.AssertNumberOfCalls(t, "ListObjectsV2", 1)
+	s3Client.AssertNumberOfCalls(t, "GetObject", 1)
+
+	// A new object under the prefix is listed and retrieved without checking huh.json again
+	received, err = provider.getConfiguration(ctx, []S3ObjectEvent{{"someBucket", "dynamic/a.yml"}})
+	require.NoError(t, err)
+	expBytes, _ = json.Marshal(json1AndYaml1)
+	assert.Equal(t, string(expBytes), string(received))
+	s3Client.AssertNumberOfCalls(t, "ListObjectsV2", 2)
+	s3Client.AssertNumberOfCalls(t, "GetObject", 2)
+}
+
+func TestNotificationTriggersImmediateRefresh(t *testing.T) {
+	stub := newStubSqs(t)
+	t.Setenv("AWS_REGION", "us-east-1")
+	provider, s3Client := newTestProvider(t, notificationSettings(stub.url), notifiedObjects...)
+	clock := newFakeClock()
+	provider.clock = clock
+	now := time.Now()
+	s3Client.On("ListObjectsV2", mock.Anything, mock.Anything, mock.Anything).Return(newListPage(), nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return arg.IfNoneMatch == nil
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("json1"),
+		Body:         io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return arg.IfNoneMatch != nil
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("json2"),
+		Body:         io.NopCloser(bytes.NewReader([]byte(json2))),
+	}, nil).Once()
+
+	cfgChan := make(chan json.Marshaler, 10)
+	require.NoError(t, provider.Provide(cfgChan))
+	t.Cleanup(func() {
+		provider.Stop()
+	})
+
+	select {
+	case <-cfgChan:
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "timed out waiting for the initial configuration")
+	}
+
+	// The poll wait never fires, so the update can only come from the notification
+	stub.send(newS3EventBody("ObjectCreated:Put", "someBucket", "huh.json"))
+	select {
+	case marshaler := <-cfgChan:
+		received, err := marshaler.MarshalJSON()
+		require.NoError(t, err)
+		expBytes, _ := json.Marshal(mustUnmarshal(t, json2))
+		assert.Equal(t, string(expBytes), string(received))
+	case <-time.After(3 * time.Second):
+		require.FailNow(t, "timed out waiting for the notified configuration")
+	}
+	assert.Len(t, clock.waits, 1, "notifications do not reset the poll wait")
+}
@@ -0,0 +1,110 @@
+package s3provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What to do with the sections of an object that are outside of its allowed paths
+type PathViolationAction string
+
+const (
+	// Fail the reload. The default
+	PathViolationReject PathViolationAction = "reject"
+	// Remove the sections and merge the rest of the object
+	PathViolationStrip PathViolationAction = "strip"
+)
+
+// Parses a path violation action. An empty name is the default reject action
+func ParsePathViolationAction(s string) (PathViolationAction, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	switch PathViolationAction(s) {
+	case "", PathViolationReject:
+		return PathViolationReject, nil
+	case PathViolationStrip:
+		return PathViolationStrip, nil
+	default:
+		return "", fmt.Errorf("%q is not a valid path violation action", s)
+	}
+}
+
+// Splits dotted paths, like "http.routers" or "*.serversTransports", into their segments
+func parsePaths(paths []string) ([][]string, error) {
+	parsed := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		segments := strings.Split(path, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+		}
+		parsed = append(parsed, segments)
+	}
+	return parsed, nil
+}
+
+// Returns the dotted path of every section of data that is under a denied path or, if there are allowed
+// paths, that is not under one of them. If strip is set, those sections are removed from data.
+func checkPaths(data map[string]interface{}, allowed [][]string, denied [][]string, strip bool) []string {
+	var violations []string
+	walkPaths(data, nil, allowed, denied, len(allowed) == 0, strip, &violations)
+	return violations
+}
+
+func walkPaths(node map[string]interface{}, path []string, allowed [][]string, denied [][]string, isAllowed bool, strip bool, violations *[]string) {
+	for _, key := range sortedKeys(node) {
+		current := append(append([]string{}, path...), key)
+		value := node[key]
+
+		violation := false
+		childAllowed := isAllowed || underAnyPath(current, allowed)
+		if underAnyPath(current, denied) {
+			violation = true
+		} else if !childAllowed {
+			// Only the ancestors of allowed paths can be walked into
+			_, isMap := value.(map[string]interface{})
+			violation = !isMap || !aboveAnyPath(current, allowed)
+		}
+
+		if violation {
+			*violations = append(*violations, strings.Join(current, "."))
+			if strip {
+				delete(node, key)
+			}
+			continue
+		}
+		if m, ok := value.(map[string]interface{}); ok {
+			walkPaths(m, current, allowed, denied, childAllowed, strip, violations)
+		}
+	}
+}
+
+// Whether path is one of the patterns or is under one of them
+func underAnyPath(path []string, patterns [][]string) bool {
+	for _, pattern := range patterns {
+		if len(pattern) <= len(path) && segmentsMatch(pattern, path[:len(pattern)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether path is an ancestor of one of the patterns
+func aboveAnyPath(path []string, patterns [][]string) bool {
+	for _, pattern := range patterns {
+		if len(path) < len(pattern) && segmentsMatch(pattern[:len(path)], path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether each segment equals the pattern's segment. A "*" segment matches anything
+func segmentsMatch(pattern []string, segments []string) bool {
+	for i, segment := range segments {
+		if pattern[i] != "*" && pattern[i] != segment {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package s3provider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pathsConfig = `{
+	"http": {
+		"routers": {"api": {"service": "api"}},
+		"services": {"api": {"loadBalancer": {}}},
+		"serversTransports": {"internal": {}}
+	},
+	"tcp": {
+		"serversTransports": {"db": {}}
+	},
+	"tls": {"options": {"modern": {}}}
+}`
+
+func TestCheckPaths(t *testing.T) {
+	var tests = []struct {
+		name       string
+		allowed    []string
+		denied     []string
+		violations []string
+		remaining  string
+	}{
+		{
+			"allowed sections",
+			[]string{"http.routers", "http.services"},
+			nil,
+			[]string{"http.serversTransports", "tcp", "tls"},
+			`{"http": {"routers": {"api": {"service": "api"}}, "services": {"api": {"loadBalancer": {}}}}}`,
+		},
+		{
+			"denied sections",
+			nil,
+			[]string{"tls", "*.serversTransports"},
+			[]string{"http.serversTransports", "tcp.serversTransports", "tls"},
+			`{"http": {"routers": {"api": {"service": "api"}}, "services": {"api": {"loadBalancer": {}}}}, "tcp": {}}`,
+		},
+		{
+			"denied under allowed",
+			[]string{"http"},
+			[]string{"http.serversTransports"},
+			[]string{"http.serversTransports", "tcp", "tls"},
+			`{"http": {"routers": {"api": {"service": "api"}}, "services": {"api": {"loadBalancer": {}}}}}`,
+		},
+		{
+			"wildcard allowed",
+			[]string{"*.serversTransports", "tls"},
+			nil,
+			[]string{"http.routers", "http.services"},
+			`{"http": {"serversTransports": {"internal": {}}}, "tcp": {"serversTransports": {"db": {}}}, "tls": {"options": {"modern": {}}}}`,
+		},
+		{
+			"specific entry",
+			[]string{"http.routers.api"},
+			nil,
+			[]string{"http.serversTransports", "http.services", "tcp", "tls"},
+			`{"http": {"routers": {"api": {"service": "api"}}}}`,
+		},
+		{
+			"everything allowed",
+			[]string{"http", "tcp", "tls"},
+			[]string{"udp"},
+			nil,
+			pathsConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := parsePaths(tt.allowed)
+			require.NoError(t, err)
+			denied, err := parsePaths(tt.denied)
+			require.NoError(t, err)
+
+			data := mustUnmarshal(t, pathsConfig)
+			assert.Equal(t, tt.violations, checkPaths(data, allowed, denied, false))
+			assert.Equal(t, mustUnmarshal(t, pathsConfig), data, "data is unchanged without strip")
+
+			assert.Equal(t, tt.violations, checkPaths(data, allowed, denied, true))
+			assert.Equal(t, mustUnmarshal(t, tt.remaining), data)
+		})
+	}
+}
+
+func TestCheckPathsNotAnObject(t *testing.T) {
+	allowed, err := parsePaths([]string{"http.routers"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http"}, checkPaths(map[string]interface{}{"http": "oops"}, allowed, nil, false))
+}
+
+// A platform object that may define anything and a team object that may only define routers and services
+func pathsObjects(pathViolation string) []string {
+	return []string{
+		`{"key": "platform.json", "bucket": "someBucket"}`,
+		`{"key": "team.json", "bucket": "someBucket", "allowedPaths": ["http.routers", "http.services"], "pathViolation": "` + pathViolation + `"}`,
+	}
+}
+
+func TestMergePathViolationReject(t *testing.T) {
+	provider, _ := newTestProvider(t, "", pathsObjects("")...)
+	provider.retrievers[0].data = &ConfigData{json: mustUnmarshal(t, `{"tls": {"options": {"default": {"minVersion": "VersionTLS12"}}}}`)}
+	provider.retrievers[1].data = &ConfigData{json: mustUnmarshal(t, pathsConfig)}
+
+	composite, err := provider.merge()
+	assert.EqualError(t, err, "someBucket/team.json defines paths it is not allowed to: http.serversTransports, tcp, tls")
+	assert.Nil(t, composite)
+}
+
+func TestMergePathViolationStrip(t *testing.T) {
+	provider, _ := newTestProvider(t, "", pathsObjects("strip")...)
+	provider.retrievers[0].data = &ConfigData{json: mustUnmarshal(t, `{"tls": {"options": {"default": {"minVersion": "VersionTLS12"}}}}`)}
+	provider.retrievers[1].data = &ConfigData{json: mustUnmarshal(t, pathsConfig)}
+
+	composite, err := provider.merge()
+	require.NoError(t, err)
+	assert.Equal(t, mustUnmarshal(t, `{
+		"http": {
+			"routers": {"api": {"service": "api"}},
+			"services": {"api": {"loadBalancer": {}}}
+		},
+		"tls": {"options": {"default": {"minVersion": "VersionTLS12"}}}
+	}`), composite)
+	// The stored data is not stripped
+	assert.Contains(t, provider.retrievers[1].data.json, "tls")
+}
+
+func TestNewPathsValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		object        string
+		expectedError string
+	}{
+		{"empty allowed segment", `"allowedPaths": ["http..routers"]`, `object[0] allowedPaths: invalid path "http..routers"`},
+		{"empty denied path", `"deniedPaths": [""]`, `object[0] deniedPaths: invalid path ""`},
+		{"bad action", `"pathViolation": "ignore"`, `object[0] "ignore" is not a valid path violation action`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [
+				{
+					"key": "huh.json",
+					"bucket": "someBucket",
+					`+tt.object+`
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"path/filepath"
+	"math/rand"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
-
-	"dario.cat/mergo"
 )
 
 type ObjectReference struct {
@@ -20,14 +22,122 @@ type ObjectReference struct {
 	Bucket string `json:"bucket"`
 	// If we cannot auto-infer the parser from the extension, you can explicitly supply this
 	Parser Parser `json:"parser,omitempty"`
+	// The name of an entry in Config.Connections to retrieve this object with. Uses the default connection if empty
+	Connection string `json:"connection,omitempty"`
+	// Instead of a Key, discover every object under this prefix on each poll. Discovered objects are merged in key order
+	Prefix string `json:"prefix,omitempty"`
+	// Instead of a Key, the key of a json or yaml manifest listing objects with the etag or versionId each must have.
+	// The listed objects are merged in order, and only once every one of them matches the manifest
+	Manifest string `json:"manifest,omitempty"`
+	// An optional glob (i.e. "*.yaml") that discovered keys, relative to the Prefix, must match
+	Glob string `json:"glob,omitempty"`
+	// An optional regular expression that discovered keys, relative to the Prefix, must match
+	Regex string `json:"regex,omitempty"`
+	// How this object is merged into the objects before it. Defaults to Config.MergeStrategy
+	MergeStrategy string `json:"mergeStrategy,omitempty"`
+	// Prepended to the names of the routers, services and middlewares that this object defines, and to the
+	// references to them within the object, so that objects owned by different teams cannot clash
+	NamePrefix string `json:"namePrefix,omitempty"`
+	// If set, the only sections (i.e. "http.routers" or "*.services") that this object may define
+	AllowedPaths []string `json:"allowedPaths,omitempty"`
+	// Sections (i.e. "tls" or "*.serversTransports") that this object may not define
+	DeniedPaths []string `json:"deniedPaths,omitempty"`
+	// What to do when the object defines a section it may not: reject (default), which fails the reload,
+	// or strip, which removes the section and merges the rest
+	PathViolation string `json:"pathViolation,omitempty"`
+	// How the object is rendered before it is parsed: none, env or gotemplate. Defaults to Config.Template
+	Template string `json:"template,omitempty"`
+	// If the object does not exist, leave it out of the merged configuration instead of failing. Objects
+	// discovered under a prefix are always optional
+	Optional bool `json:"optional,omitempty"`
+	// Pin the object to this version in a versioned bucket. Empty or "latest" retrieves the latest version
+	VersionID string `json:"versionId,omitempty"`
+	// The key of a small object in the same bucket whose contents are the version id to retrieve (or "latest"),
+	// so that the pinned version can be promoted or rolled back by rewriting the pointer
+	VersionPointer string `json:"versionPointer,omitempty"`
+	// The base64 encoded key that the object, and its version pointer or manifest, are encrypted with using SSE-C
+	SSECustomerKey string `json:"sseCustomerKey,omitempty"`
+	// A file holding the raw or base64 encoded SSE-C key, re-read when modified
+	SSECustomerKeyFile string `json:"sseCustomerKeyFile,omitempty"`
+	// The SSE-C algorithm. Defaults to AES256, the only one s3 supports
+	SSECustomerAlgorithm string `json:"sseCustomerAlgorithm,omitempty"`
 }
 
+// The default number of objects that are retrieved at the same time
+const defaultMaxConcurrentRequests = 10
+
 // Config the plugin configuration.
 type Config struct {
 	// A Golang duration string for the interval at which we check for changes
 	PollInterval string `json:"pollInterval,omitempty"`
+	// A Golang duration string. Up to this much random time is added to every poll interval so that
+	// many traefik replicas do not poll in lockstep
+	PollJitter string `json:"pollJitter,omitempty"`
+	// A Golang duration string for the delay after a failed poll. Enables exponential retry backoff. Each wait is a
+	// random time between half the delay and the delay
+	RetryInitialInterval string `json:"retryInitialInterval,omitempty"`
+	// A Golang duration string for the longest wait between failed polls. Defaults to the poll interval
+	RetryMaxInterval string `json:"retryMaxInterval,omitempty"`
+	// How much the wait grows with each consecutive failed poll. Defaults to 2
+	RetryMultiplier float64 `json:"retryMultiplier,omitempty"`
 	// A list of s3 bucket objects
 	Objects []ObjectReference `json:"objects"`
+	// How each object is merged into the objects before it: append (default), replace, override-later-wins
+	// or deep-merge-with-slice-dedupe
+	MergeStrategy string `json:"mergeStrategy,omitempty"`
+	// What to do when objects define the same router, service, middleware or servers transport: ignore (default),
+	// warn, fail or last-wins
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	// How objects are rendered before they are parsed: none (default), env for ${VAR} expansion, or gotemplate for
+	// Go text/template with {{ .VAR }}. Variables come from Variables and then the environment
+	Template string `json:"template,omitempty"`
+	// Variables for templated objects. These take precedence over environment variables of the same name
+	Variables map[string]string `json:"variables,omitempty"`
+	// An age key file (as created by age-keygen) on the traefik host. Objects that are age encrypted, or that are
+	// sops encrypted json or yaml with an age recipient, are decrypted with it before they are parsed
+	AgeKeyFile string `json:"ageKeyFile,omitempty"`
+	// The most bytes that an object compressed with gzip or zstd (by its Content-Encoding or a .gz or .zst key)
+	// can decompress to. Defaults to 64MiB
+	MaxDecompressedSize int64 `json:"maxDecompressedSize,omitempty"`
+	// Check the merged configuration against the structure of traefik's dynamic configuration. An invalid
+	// configuration is reported as an error instead of being provided, so traefik keeps the last good one
+	ValidateSchema bool `json:"validateSchema,omitempty"`
+	// A shared credentials file that is re-read whenever it changes so that keys can be rotated
+	// without restarting traefik. Uses the AWS_PROFILE profile or "default".
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// A static access key id for the default connection
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	// A file holding the access key id for the default connection, re-read when modified
+	AccessKeyIDFile string `json:"accessKeyIdFile,omitempty"`
+	// A static secret access key for the default connection
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	// A file holding the secret access key for the default connection, re-read when modified
+	SecretAccessKeyFile string `json:"secretAccessKeyFile,omitempty"`
+	// An optional session token to go with the default connection's static credentials
+	SessionToken string `json:"sessionToken,omitempty"`
+	// A role for the default connection to assume. The session is renewed before it expires
+	RoleArn string `json:"roleArn,omitempty"`
+	// The external id required by the role's trust policy, if any
+	ExternalID string `json:"externalId,omitempty"`
+	// The name of the assumed role session. Defaults to traefik-s3provider
+	SessionName string `json:"sessionName,omitempty"`
+	// A Golang duration string for how long each assumed role session lasts
+	Duration string `json:"duration,omitempty"`
+	// Named s3 connection settings that objects can refer to if they are not in the default object store
+	Connections map[string]ClientConfig `json:"connections,omitempty"`
+	// If an object cannot be retrieved or parsed, log the error and keep merging its last successfully parsed data
+	// instead of failing the whole configuration
+	KeepLastKnownGood bool `json:"keepLastKnownGood,omitempty"`
+	// The most objects that are retrieved at the same time. Defaults to 10
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+	// A Golang duration string for how long each object request can take before it is abandoned
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+	// A directory to write every merged configuration that has every object in it to. If the first poll after
+	// starting fails, or some of its objects do, the cached configuration is provided instead
+	CacheDir string `json:"cacheDir,omitempty"`
+	// An sqs queue receiving s3 event notifications from the buckets. Objects named in ObjectCreated and ObjectRemoved
+	// events are refreshed immediately, with polling kept as a fallback
+	Notifications *NotificationConfig `json:"notifications,omitempty"`
 }
 
 // Simple trusted marshaler that returns bytes
@@ -47,10 +157,42 @@ func CreateConfig() *Config {
 
 // Provider a simple provider plugin.
 type Provider struct {
-	name         string
-	pollInterval time.Duration
-	// 1 retriever per bucket object
+	name string
+	// How long to wait between polls
+	schedule pollSchedule
+	// What the provider waits on between polls
+	clock Clock
+	// The number of polls in a row that have had an error
+	consecutiveFailures int
+	// 1 source per configured object, in the order they are merged
+	sources []retrieverSource
+	// 1 retriever per bucket object, in the order they are merged
 	retrievers []*S3ObjectRetriever
+	// Whether to keep using the last good data of objects that fail
+	keepLastKnownGood bool
+	// What to do when objects define the same named entry
+	conflictPolicy ConflictPolicy
+	// Whether to check the merged configuration against the dynamic configuration schema
+	validateSchema bool
+	// The most objects that are retrieved at the same time
+	maxConcurrentRequests int
+	// How long each object request can take. No limit if 0
+	requestTimeout time.Duration
+	// The on disk copy of the last merged configuration, if configured
+	cache *configCache
+	// Whether a configuration has been sent to traefik yet
+	provided bool
+	// Whether the configuration sent to traefik is the cached one, which is kept until every object is retrieved
+	fromCache bool
+	// Whether some objects or sources failed, so the merged configuration is missing their current data
+	incomplete bool
+	// Whether objects may have changed since the configuration last sent to traefik, i.e. because a poll
+	// errored after retrieving them, so the next poll merges even if no object changes
+	stale bool
+	// Receives s3 event notifications, if configured
+	listener *S3EventListener
+	// The object events from the listener
+	events chan []S3ObjectEvent
 
 	// The context cancel function for stopping our provider's goroutines
 	cancel func()
@@ -67,54 +209,433 @@ func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 		return nil, errors.New("poll interval must be greater than 0")
 	}
 
+	schedule, err := newPollSchedule(config, pi)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(config.Objects) == 0 {
 		return nil, errors.New("objects must be non-empty to use s3 provider")
 	}
 
-	s3Client, err := NewS3Client()
+	settings, err := newSourceSettings(config)
+	if err != nil {
+		return nil, err
+	}
+	conflictPolicy, err := ParseConflictPolicy(config.ConflictPolicy)
 	if err != nil {
 		return nil, err
 	}
 
-	numObjs := len(config.Objects)
-	retrievers := make([]*S3ObjectRetriever, numObjs)
-	for idx, obj := range config.Objects {
-		// index is the index where we are
-		// element is the element from someSlice for where we are
-		if len(obj.Key) == 0 {
-			return nil, fmt.Errorf("object[%d] cannot have empty key %v", idx, obj)
-		}
-		if len(obj.Bucket) == 0 {
-			return nil, fmt.Errorf("object[%d] cannot have empty bucket name %v", idx, obj)
-		}
-		if obj.Parser == Unknown {
-			switch filepath.Ext(obj.Key) {
-			case ".yaml":
-				obj.Parser = Yaml
-			case ".yml":
-				obj.Parser = Yaml
-			case ".json":
-				obj.Parser = Json
-			default:
-				return nil, fmt.Errorf("object[%d] cannot infer parser for key %s. Must have a known extension or explicitly set parser", idx, obj.Key)
-			}
+	sources, retrievers, err := newSources(ctx, config.Objects, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache *configCache
+	if config.CacheDir != "" {
+		cache, err = newConfigCache(config.CacheDir, name)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		// Create the object retriever that we can re-apply
-		retrievers[idx] = NewS3ObjectRetriever(s3Client, RetrieverConfig{
-			Bucket: obj.Bucket,
-			Key: obj.Key,
-			Parser: obj.Parser,
-		})
+	listener, err := newProviderListener(ctx, config.Notifications, settings)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Provider{
-		name:         name,
-		pollInterval: pi,
-		retrievers:   retrievers,
+		name:                  name,
+		schedule:              schedule,
+		clock:                 realClock{},
+		sources:               sources,
+		retrievers:            retrievers,
+		keepLastKnownGood:     config.KeepLastKnownGood,
+		conflictPolicy:        conflictPolicy,
+		validateSchema:        config.ValidateSchema,
+		maxConcurrentRequests: settings.maxConcurrentRequests,
+		requestTimeout:        settings.requestTimeout,
+		cache:                 cache,
+		listener:              listener,
+		events:                make(chan []S3ObjectEvent),
 	}, nil
 }
 
+// The provider wide settings that every object's source is created with
+type sourceSettings struct {
+	// The connection of objects that do not name one
+	defaultConnection ClientConfig
+	// The named connections that objects can refer to
+	connections map[string]ClientConfig
+	// Shares an s3 client between the objects that use the same connection
+	clients *clientCache
+	// How objects are merged unless they set their own strategy
+	defaultStrategy MergeStrategy
+	// How objects are rendered unless they set their own template mode
+	defaultTemplate TemplateMode
+	// The variables for templated objects
+	variables map[string]string
+	// Decrypts age and sops encrypted objects, if configured
+	decrypter *Decrypter
+	// The most bytes that a compressed object can decompress to
+	maxDecompressedSize int64
+	// The most objects that are retrieved at the same time
+	maxConcurrentRequests int
+	// How long each object request can take. No limit if 0
+	requestTimeout time.Duration
+}
+
+// Parses and checks the settings that apply to every object
+func newSourceSettings(config *Config) (sourceSettings, error) {
+	settings := sourceSettings{
+		defaultConnection: ClientConfig{
+			CredentialsFile:     config.CredentialsFile,
+			AccessKeyID:         config.AccessKeyID,
+			AccessKeyIDFile:     config.AccessKeyIDFile,
+			SecretAccessKey:     config.SecretAccessKey,
+			SecretAccessKeyFile: config.SecretAccessKeyFile,
+			SessionToken:        config.SessionToken,
+			RoleArn:             config.RoleArn,
+			ExternalID:          config.ExternalID,
+			SessionName:         config.SessionName,
+			Duration:            config.Duration,
+		},
+		connections:         config.Connections,
+		clients:             newClientCache(),
+		variables:           config.Variables,
+		maxDecompressedSize: config.MaxDecompressedSize,
+	}
+	if err := settings.defaultConnection.Validate(); err != nil {
+		return settings, err
+	}
+	for name, connection := range config.Connections {
+		if err := connection.Validate(); err != nil {
+			return settings, fmt.Errorf("connection %s: %w", name, err)
+		}
+	}
+
+	var err error
+	if settings.defaultStrategy, err = ParseMergeStrategy(config.MergeStrategy); err != nil {
+		return settings, err
+	}
+	if settings.defaultTemplate, err = ParseTemplateMode(config.Template); err != nil {
+		return settings, err
+	}
+	if config.MaxDecompressedSize < 0 {
+		return settings, errors.New("max decompressed size cannot be negative")
+	}
+	if config.AgeKeyFile != "" {
+		if settings.decrypter, err = NewDecrypter(config.AgeKeyFile); err != nil {
+			return settings, err
+		}
+	}
+	settings.maxConcurrentRequests, settings.requestTimeout, err = parseRequestLimits(config)
+	return settings, err
+}
+
+// Parses how many object requests can be made at the same time and how long each can take
+func parseRequestLimits(config *Config) (int, time.Duration, error) {
+	maxConcurrentRequests := config.MaxConcurrentRequests
+	if maxConcurrentRequests == 0 {
+		maxConcurrentRequests = defaultMaxConcurrentRequests
+	}
+	if maxConcurrentRequests < 0 {
+		return 0, 0, errors.New("max concurrent requests must be greater than 0")
+	}
+	if config.RequestTimeout == "" {
+		return maxConcurrentRequests, 0, nil
+	}
+	requestTimeout, err := time.ParseDuration(config.RequestTimeout)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid request timeout: %w", err)
+	}
+	if requestTimeout <= 0 {
+		return 0, 0, errors.New("request timeout must be greater than 0")
+	}
+	return maxConcurrentRequests, requestTimeout, nil
+}
+
+// Returns the connection with the given name, or the default connection if the name is empty
+func (settings sourceSettings) connection(name string) (ClientConfig, bool) {
+	if name == "" {
+		return settings.defaultConnection, true
+	}
+	connection, ok := settings.connections[name]
+	return connection, ok
+}
+
+// Creates 1 source per configured object, and returns the retrievers of the objects with a fixed key
+func newSources(ctx context.Context, objects []ObjectReference, settings sourceSettings) ([]retrieverSource, []*S3ObjectRetriever, error) {
+	sources := make([]retrieverSource, len(objects))
+	var retrievers []*S3ObjectRetriever
+	for idx, obj := range objects {
+		source, err := newSource(ctx, obj, settings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("object[%d] %w", idx, err)
+		}
+		sources[idx] = source
+		if static, ok := source.(staticSource); ok {
+			retrievers = append(retrievers, static.retriever)
+		}
+	}
+	return sources, retrievers, nil
+}
+
+// Creates the source of an object: a prefix discoverer, a manifest, or a retriever for a single key
+func newSource(ctx context.Context, obj ObjectReference, settings sourceSettings) (retrieverSource, error) {
+	if err := validateObject(obj); err != nil {
+		return nil, err
+	}
+	options, err := parseObjectOptions(obj, settings.defaultStrategy)
+	if err != nil {
+		return nil, err
+	}
+	templater, err := newObjectTemplater(obj, settings)
+	if err != nil {
+		return nil, err
+	}
+	sseCustomerKey, err := newObjectSSECustomerKey(obj)
+	if err != nil {
+		return nil, err
+	}
+	connection, ok := settings.connection(obj.Connection)
+	if !ok {
+		return nil, fmt.Errorf("references unknown connection %s", obj.Connection)
+	}
+	s3Client, err := settings.clients.get(ctx, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(obj.Prefix) != 0:
+		regex, err := parseDiscoveryFilter(obj)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3PrefixDiscoverer(s3Client, PrefixConfig{
+			Bucket:              obj.Bucket,
+			Prefix:              obj.Prefix,
+			Glob:                obj.Glob,
+			Regex:               regex,
+			Parser:              obj.Parser,
+			Template:            templater,
+			MaxDecompressedSize: settings.maxDecompressedSize,
+			SSECustomerKey:      sseCustomerKey,
+			Decrypter:           settings.decrypter,
+			ObjectOptions:       options,
+		}), nil
+	case len(obj.Manifest) != 0:
+		return NewS3ManifestSource(s3Client, ManifestConfig{
+			Bucket:                obj.Bucket,
+			Key:                   obj.Manifest,
+			Template:              templater,
+			MaxDecompressedSize:   settings.maxDecompressedSize,
+			SSECustomerKey:        sseCustomerKey,
+			Decrypter:             settings.decrypter,
+			MaxConcurrentRequests: settings.maxConcurrentRequests,
+			RequestTimeout:        settings.requestTimeout,
+			ObjectOptions:         options,
+		}), nil
+	}
+
+	if obj.Parser == Unknown {
+		if obj.Parser, err = InferParser(obj.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the object retriever that we can re-apply
+	return staticSource{retriever: NewS3ObjectRetriever(s3Client, RetrieverConfig{
+		Bucket:              obj.Bucket,
+		Key:                 obj.Key,
+		Parser:              obj.Parser,
+		Template:            templater,
+		MaxDecompressedSize: settings.maxDecompressedSize,
+		Optional:            obj.Optional,
+		VersionID:           obj.VersionID,
+		VersionPointer:      obj.VersionPointer,
+		SSECustomerKey:      sseCustomerKey,
+		Decrypter:           settings.decrypter,
+		ObjectOptions:       options,
+	})}, nil
+}
+
+// Checks that an object names its bucket and exactly one of a key, prefix or manifest, with only the settings
+// that apply to it
+func validateObject(obj ObjectReference) error {
+	if len(obj.Bucket) == 0 {
+		return fmt.Errorf("cannot have empty bucket name %v", obj)
+	}
+	switch {
+	case len(obj.Manifest) != 0:
+		return validateManifestObject(obj)
+	case len(obj.Prefix) != 0:
+		return validatePrefixObject(obj)
+	case len(obj.Key) != 0:
+		return validateKeyObject(obj)
+	}
+	return fmt.Errorf("cannot have empty key %v", obj)
+}
+
+func validateManifestObject(obj ObjectReference) error {
+	if len(obj.Key) != 0 || len(obj.Prefix) != 0 {
+		return fmt.Errorf("cannot have a manifest with a key or a prefix %v", obj)
+	}
+	if obj.Optional || len(obj.VersionID) != 0 || len(obj.VersionPointer) != 0 || obj.Parser != Unknown {
+		return fmt.Errorf("cannot be optional or set a version or parser with a manifest since the manifest lists them %v", obj)
+	}
+	return nil
+}
+
+func validatePrefixObject(obj ObjectReference) error {
+	if len(obj.Key) != 0 {
+		return fmt.Errorf("cannot have both a key and a prefix %v", obj)
+	}
+	if obj.Optional {
+		return fmt.Errorf("cannot be optional with a prefix since discovered objects already are %v", obj)
+	}
+	if len(obj.VersionID) != 0 || len(obj.VersionPointer) != 0 {
+		return fmt.Errorf("cannot pin a version with a prefix %v", obj)
+	}
+	return nil
+}
+
+func validateKeyObject(obj ObjectReference) error {
+	if len(obj.Glob) != 0 || len(obj.Regex) != 0 {
+		return fmt.Errorf("can only use glob or regex with a prefix %v", obj)
+	}
+	if len(obj.VersionID) != 0 && len(obj.VersionPointer) != 0 {
+		return fmt.Errorf("cannot have both a versionId and a versionPointer %v", obj)
+	}
+	return nil
+}
+
+// Parses how an object is merged and which sections it may define
+func parseObjectOptions(obj ObjectReference, defaultStrategy MergeStrategy) (ObjectOptions, error) {
+	options := ObjectOptions{
+		MergeStrategy: defaultStrategy,
+		NamePrefix:    obj.NamePrefix,
+	}
+	var err error
+	if obj.MergeStrategy != "" {
+		if options.MergeStrategy, err = ParseMergeStrategy(obj.MergeStrategy); err != nil {
+			return options, err
+		}
+	}
+	if options.AllowedPaths, err = parsePaths(obj.AllowedPaths); err != nil {
+		return options, fmt.Errorf("allowedPaths: %w", err)
+	}
+	if options.DeniedPaths, err = parsePaths(obj.DeniedPaths); err != nil {
+		return options, fmt.Errorf("deniedPaths: %w", err)
+	}
+	options.PathViolation, err = ParsePathViolationAction(obj.PathViolation)
+	return options, err
+}
+
+// Returns the templater that renders an object before it is parsed
+func newObjectTemplater(obj ObjectReference, settings sourceSettings) (*Templater, error) {
+	templateMode := settings.defaultTemplate
+	if obj.Template != "" {
+		var err error
+		if templateMode, err = ParseTemplateMode(obj.Template); err != nil {
+			return nil, err
+		}
+	}
+	return NewTemplater(templateMode, settings.variables), nil
+}
+
+// Returns the SSE-C key that an object is encrypted with, or nil if it is not
+func newObjectSSECustomerKey(obj ObjectReference) (*SSECustomerKey, error) {
+	if obj.SSECustomerKey == "" && obj.SSECustomerKeyFile == "" && obj.SSECustomerAlgorithm == "" {
+		return nil, nil
+	}
+	return NewSSECustomerKey(obj.SSECustomerAlgorithm, obj.SSECustomerKey, obj.SSECustomerKeyFile)
+}
+
+// Checks the glob and compiles the regex that the keys discovered under a prefix must match
+func parseDiscoveryFilter(obj ObjectReference) (*regexp.Regexp, error) {
+	if _, err := path.Match(obj.Glob, ""); err != nil {
+		return nil, fmt.Errorf("has invalid glob %s: %w", obj.Glob, err)
+	}
+	if len(obj.Regex) == 0 {
+		return nil, nil
+	}
+	regex, err := regexp.Compile(obj.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("has invalid regex %s: %w", obj.Regex, err)
+	}
+	return regex, nil
+}
+
+// Creates the listener for the s3 event notifications, if configured
+func newProviderListener(ctx context.Context, notifications *NotificationConfig, settings sourceSettings) (*S3EventListener, error) {
+	if notifications == nil {
+		return nil, nil
+	}
+	connection, ok := settings.connection(notifications.Connection)
+	if !ok {
+		return nil, fmt.Errorf("notifications reference unknown connection %s", notifications.Connection)
+	}
+	return newNotificationListener(ctx, *notifications, connection)
+}
+
+// Parses the polling and retry settings
+func newPollSchedule(config *Config, interval time.Duration) (pollSchedule, error) {
+	schedule := pollSchedule{
+		interval:        interval,
+		retryMax:        interval,
+		retryMultiplier: 2,
+		random:          rand.Float64,
+	}
+
+	var err error
+	if config.PollJitter != "" {
+		if schedule.jitter, err = time.ParseDuration(config.PollJitter); err != nil {
+			return schedule, fmt.Errorf("invalid poll jitter: %w", err)
+		}
+		if schedule.jitter < 0 {
+			return schedule, errors.New("poll jitter cannot be negative")
+		}
+	}
+
+	err = schedule.parseRetryBackoff(config)
+	return schedule, err
+}
+
+// Parses the exponential backoff between failed polls, which is disabled unless a retry initial interval is set
+func (schedule *pollSchedule) parseRetryBackoff(config *Config) error {
+	if config.RetryInitialInterval == "" {
+		if config.RetryMaxInterval != "" || config.RetryMultiplier != 0 {
+			return errors.New("retryMaxInterval and retryMultiplier require a retryInitialInterval")
+		}
+		return nil
+	}
+	var err error
+	if schedule.retryInitial, err = time.ParseDuration(config.RetryInitialInterval); err != nil {
+		return fmt.Errorf("invalid retry initial interval: %w", err)
+	}
+	if schedule.retryInitial <= 0 {
+		return errors.New("retry initial interval must be greater than 0")
+	}
+	if config.RetryMaxInterval != "" {
+		if schedule.retryMax, err = time.ParseDuration(config.RetryMaxInterval); err != nil {
+			return fmt.Errorf("invalid retry max interval: %w", err)
+		}
+	}
+	if schedule.retryMax < schedule.retryInitial {
+		return errors.New("retry max interval cannot be less than the retry initial interval")
+	}
+	if config.RetryMultiplier != 0 {
+		if config.RetryMultiplier < 1 {
+			return errors.New("retry multiplier must be at least 1")
+		}
+		schedule.retryMultiplier = config.RetryMultiplier
+	}
+	return nil
+}
+
 // Init the provider.
 func (p *Provider) Init() error {
 	return nil
@@ -135,28 +656,62 @@ func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
 		p.pollConfiguration(ctx, cfgChan)
 	}()
 
+	if p.listener != nil {
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Print(err)
+				}
+			}()
+
+			p.listener.Listen(ctx, p.events)
+		}()
+	}
+
 	return nil
 }
 
 func (p *Provider) pollConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) {
-	// Run immediately
-	p.provideConfiguration(ctx, cfgChan)
-	ticker := time.NewTicker(p.pollInterval)
-	defer ticker.Stop()
-
+	// Runs immediately and then after each wait
+	p.provideConfiguration(ctx, cfgChan, nil)
+	wait := p.clock.After(p.schedule.next(p.consecutiveFailures))
 	for {
 		select {
-		case <-ticker.C:
-			// Check on intervals
-			p.provideConfiguration(ctx, cfgChan)
+		case <-wait:
+			p.provideConfiguration(ctx, cfgChan, nil)
+			wait = p.clock.After(p.schedule.next(p.consecutiveFailures))
+		case events := <-p.events:
+			// Refresh just the notified objects without resetting the poll wait
+			p.provideConfiguration(ctx, cfgChan, events)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (p *Provider) provideConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) {
-	data, err := p.getConfiguration(ctx)
+// Sends any change in configuration to traefik. If events is nil every object is checked, otherwise only
+// the objects in the events are
+func (p *Provider) provideConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler, events []S3ObjectEvent) {
+	data, err := p.getConfiguration(ctx, events)
+	switch {
+	case err == nil && !p.incomplete:
+		// Only a configuration with every object in it is cached
+		if data != nil {
+			p.provided = true
+			p.fromCache = false
+			p.saveCache(data)
+		}
+	case err == nil && p.fromCache:
+		// Keep the cached configuration instead of replacing it with a partial one, and merge again once
+		// every object is retrieved even if none of them change
+		if data != nil {
+			log.Print("keeping cached configuration until every object is retrieved")
+		}
+		p.stale = true
+		return
+	case !p.provided:
+		data, err = p.startFromCache(data, err)
+	}
 	if err != nil || data != nil {
 		cfgChan <- BytesProvider(func() ([]byte, error) {
 			return data, err
@@ -164,53 +719,298 @@ func (p *Provider) provideConfiguration(ctx context.Context, cfgChan chan<- json
 	}
 }
 
+// Returns the cached configuration in place of the result of the first poll, if we could not get every object
+// from the object store on startup. Otherwise the result is returned unchanged
+func (p *Provider) startFromCache(data []byte, err error) ([]byte, error) {
+	cached := p.loadCache()
+	if cached == nil {
+		return data, err
+	}
+	if err != nil {
+		log.Printf("providing cached configuration after initial poll failure: %v", err)
+	} else {
+		log.Print("providing cached configuration since some objects failed in the initial poll")
+	}
+	p.provided = true
+	p.fromCache = true
+	p.stale = true
+	return cached, nil
+}
+
+func (p *Provider) saveCache(data []byte) {
+	if p.cache == nil {
+		return
+	}
+	etags := make(map[string]string, len(p.retrievers))
+	for _, retriever := range p.retrievers {
+		if retriever.data != nil {
+			etags[retriever.Bucket+"/"+retriever.Key] = retriever.data.etag
+		}
+	}
+	if err := p.cache.Save(data, etags); err != nil {
+		log.Printf("unable to cache configuration: %v", err)
+	}
+}
+
+func (p *Provider) loadCache() []byte {
+	if p.cache == nil {
+		return nil
+	}
+	cached, err := p.cache.Load()
+	if err != nil {
+		log.Printf("unable to load cached configuration: %v", err)
+		return nil
+	}
+	if cached == nil {
+		return nil
+	}
+	return cached.Configuration
+}
+
 // Stop to stop the provider and the related go routines.
 func (p *Provider) Stop() error {
 	p.cancel()
 	return nil
 }
 
-func (p *Provider) getConfiguration(ctx context.Context) ([]byte, error) {
-	var err error
+func (p *Provider) getConfiguration(ctx context.Context, events []S3ObjectEvent) (_ []byte, err error) {
+	failed := false
+	defer func() {
+		p.recordPoll(events, failed, err)
+	}()
+
+	// Check to see if any objects were added or removed
+	sourcesChanged, failed, err := p.refreshRetrievers(ctx, events)
+	if err != nil {
+		return make([]byte, 0), err
+	}
+
 	// Check to see if the file has changed
-	hasChanged := false
-	for _, retriever := range p.retrievers {
-		var changed bool
-		changed, err = retriever.HasChanged(ctx)
-		if err != nil {
-			break
+	objectsChanged, objectsFailed, err := p.retrieveChanges(ctx, events)
+	failed = failed || objectsFailed
+
+	// If we can't get a config, we pass it as a marshalling failure
+	if err != nil {
+		return make([]byte, 0), err
+	}
+
+	if !sourcesChanged && !objectsChanged && !p.stale {
+		return nil, nil
+	}
+	return p.mergedConfiguration()
+}
+
+// Records the outcome of a poll, or of a notified refresh if events is not nil
+func (p *Provider) recordPoll(events []S3ObjectEvent, failed bool, err error) {
+	// Objects retrieved in a poll that errored may have changed without being sent
+	if err != nil {
+		p.stale = true
+	}
+	failed = failed || err != nil
+
+	// Notified refreshes only check some objects so they cannot tell whether the others have recovered
+	if failed {
+		p.incomplete = true
+	} else if events == nil {
+		p.incomplete = false
+	}
+
+	// Any object error, even one that we recover from, counts as a failed poll for retry backoff.
+	// Notified refreshes only check some objects so they do not count
+	if events != nil {
+		return
+	}
+	if failed {
+		p.consecutiveFailures++
+	} else {
+		p.consecutiveFailures = 0
+	}
+}
+
+// Retrieves every object, or only the notified ones if events is not nil, and returns whether any changed and
+// whether any failed. Unless the last known good data is kept, the first failure is returned as the error
+func (p *Provider) retrieveChanges(ctx context.Context, events []S3ObjectEvent) (changed bool, failed bool, err error) {
+	results := p.retrieveAll(ctx, events)
+	for idx, retriever := range p.retrievers {
+		if results[idx].err == nil {
+			changed = changed || results[idx].changed
+			continue
 		}
-		if changed {
-			err = retriever.Retrieve(ctx)
-			if err != nil {
-				break
-			}
-			hasChanged = true
+		if !p.keepLastKnownGood {
+			return changed, failed, results[idx].err
 		}
+		log.Printf("keeping last known good data for %s/%s after error: %v", retriever.Bucket, retriever.Key, results[idx].err)
+		failed = true
 	}
+	return changed, failed, nil
+}
 
-	// If we can't get a config, we pass it as a marshalling failure
+// Merges the data of every object, checks it against the schema if configured, and marshals it for traefik
+func (p *Provider) mergedConfiguration() ([]byte, error) {
+	// Remerge the json to ensure there's appropriate overriding
+	composite, err := p.merge()
+	if err == nil && p.validateSchema {
+		err = validateDynamicConfig(composite)
+	}
+
+	// Pass the error as a marshalling error to traefik
 	if err != nil {
 		return make([]byte, 0), err
 	}
+	p.stale = false
+	return json.Marshal(composite)
+}
 
-	if hasChanged {
-		var composite map[string]interface{} = make(map[string]interface{})
-		// Remerge the json to ensure there's appropriate overriding
-		for _, retriever := range p.retrievers {
-			err = mergo.Merge(&composite, retriever.data.json, mergo.WithAppendSlice)
-			if err != nil {
-				break
+// Merges the data of every retriever in order, handling entries that are defined by more than one object
+// according to the conflict policy
+func (p *Provider) merge() (map[string]interface{}, error) {
+	var composite map[string]interface{} = make(map[string]interface{})
+	detector := newConflictDetector()
+	var conflicts []string
+	for _, retriever := range p.retrievers {
+		// Objects that have never been retrieved successfully have nothing to contribute
+		if retriever.data == nil {
+			continue
+		}
+		// Merge a copy since mergo reuses nested maps from the source, which would be mutated by later merges
+		data := copyJson(retriever.data.json).(map[string]interface{})
+		source := retriever.Bucket + "/" + retriever.Key
+		if len(retriever.AllowedPaths) > 0 || len(retriever.DeniedPaths) > 0 {
+			strip := retriever.PathViolation == PathViolationStrip
+			if violations := checkPaths(data, retriever.AllowedPaths, retriever.DeniedPaths, strip); len(violations) > 0 {
+				if !strip {
+					return nil, fmt.Errorf("%s defines paths it is not allowed to: %s", source, strings.Join(violations, ", "))
+				}
+				log.Printf("stripped paths that %s is not allowed to define: %s", source, strings.Join(violations, ", "))
 			}
 		}
+		// Paths are checked against the names in the object, so prefix afterwards
+		if retriever.NamePrefix != "" {
+			prefixNames(data, retriever.NamePrefix)
+		}
+
+		if p.conflictPolicy != ConflictIgnore {
+			conflicts = append(conflicts, p.applyConflictPolicy(composite, detector.add(source, data))...)
+		}
 
-		// Pass the error as a marshalling error to traefik
+		if err := mergeJson(&composite, data, retriever.MergeStrategy); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("conflicting definitions: %s", strings.Join(conflicts, "; "))
+	}
+	return composite, nil
+}
+
+// Logs the conflicts, or removes the earlier definitions from the composite with the last-wins policy, and
+// returns the conflicts that fail the merge
+func (p *Provider) applyConflictPolicy(composite map[string]interface{}, found []conflict) []string {
+	var failures []string
+	for _, c := range found {
+		switch p.conflictPolicy {
+		case ConflictFail:
+			failures = append(failures, c.String())
+		case ConflictLastWins:
+			log.Printf("conflict: %s, using the definition from %s", c, c.second)
+			deleteJson(composite, c.path)
+		default:
+			log.Printf("conflict: %s", c)
+		}
+	}
+	return failures
+}
+
+// The outcome of a single retriever's Retrieve
+type retrieveResult struct {
+	changed bool
+	err     error
+}
+
+// Retrieves every object, or only those in events if it is not nil, concurrently with at most maxConcurrentRequests
+// in flight and returns the results in the same order as p.retrievers
+func (p *Provider) retrieveAll(ctx context.Context, events []S3ObjectEvent) []retrieveResult {
+	return retrieveConcurrently(ctx, p.retrievers, events, p.maxConcurrentRequests, p.requestTimeout)
+}
+
+// Retrieves the objects, or only those in events if it is not nil, with at most maxConcurrentRequests in flight
+// and each request limited to requestTimeout if it is not 0. The results are in the same order as the retrievers
+func retrieveConcurrently(ctx context.Context, retrievers []*S3ObjectRetriever, events []S3ObjectEvent, maxConcurrentRequests int, requestTimeout time.Duration) []retrieveResult {
+	results := make([]retrieveResult, len(retrievers))
+	sem := make(chan struct{}, maxConcurrentRequests)
+	var wg sync.WaitGroup
+	for idx, retriever := range retrievers {
+		if events != nil && !retriever.affectedBy(events) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int, retriever *S3ObjectRetriever) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			reqCtx := ctx
+			if requestTimeout > 0 {
+				var cancel context.CancelFunc
+				reqCtx, cancel = context.WithTimeout(ctx, requestTimeout)
+				defer cancel()
+			}
+			changed, err := retriever.Retrieve(reqCtx)
+			results[idx] = retrieveResult{changed: changed, err: err}
+		}(idx, retriever)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Rebuilds the ordered list of retrievers from each source, returning whether the set of objects changed and
+// whether any source failed but kept its last known objects. If events is not nil, only the sources affected
+// by them are refreshed
+func (p *Provider) refreshRetrievers(ctx context.Context, events []S3ObjectEvent) (bool, bool, error) {
+	hasChanged := false
+	failed := false
+	var retrievers []*S3ObjectRetriever
+	for _, source := range p.sources {
+		if events != nil && !source.affectedBy(events) {
+			retrievers = append(retrievers, source.current()...)
+			continue
+		}
+		sourceRetrievers, changed, err := source.Retrievers(ctx)
 		if err != nil {
-			return make([]byte, 0), err
-		} else {
-			return json.Marshal(composite)
+			if !p.keepLastKnownGood {
+				return false, true, err
+			}
+			log.Printf("keeping last known good objects after error: %v", err)
+			failed = true
 		}
+		hasChanged = hasChanged || changed
+		retrievers = append(retrievers, sourceRetrievers...)
 	}
+	p.retrievers = retrievers
+
+	return hasChanged, failed, nil
+}
 
-	return nil, nil
+// Deep copies the maps and slices of decoded json so the original is not modified
+func copyJson(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[key] = copyJson(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, el := range v {
+			s[i] = copyJson(el)
+		}
+		return s
+	default:
+		return v
+	}
 }
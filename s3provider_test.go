@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -52,6 +57,8 @@ tls:
       keyFile: /path/to/domain.key
     - certFile: /path/to/other-domain.cert
       keyFile: /path/to/other-domain.key`
+	jsonObject = `{"key": "huh.json", "bucket": "someBucket"}`
+	yamlObject = `{"key": "f.yml", "bucket": "someBucket"}`
 )
 
 var (
@@ -172,15 +179,20 @@ func TestNewObjectsInferredParser(t *testing.T) {
 		{
 			"key": "f.yaml",
 			"bucket": "someBucket"
+		},
+		{
+			"key": "f.toml",
+			"bucket": "someBucket"
 		}
 	]}`), &config)
 
 	provider, err := New(context.Background(), &config, "test")
 	require.Nil(t, err)
-	require.Len(t, provider.retrievers, 3)
+	require.Len(t, provider.retrievers, 4)
 	require.Equal(t, Json, provider.retrievers[0].RetrieverConfig.Parser)
 	require.Equal(t, Yaml, provider.retrievers[1].RetrieverConfig.Parser)
 	require.Equal(t, Yaml, provider.retrievers[2].RetrieverConfig.Parser)
+	require.Equal(t, Toml, provider.retrievers[3].RetrieverConfig.Parser)
 }
 
 func TestNewObjectsInferredParserValidationSyntax(t *testing.T) {
@@ -208,6 +220,159 @@ func TestNewObjectsInferredParserValidationSyntax(t *testing.T) {
 	require.ErrorContains(t, err, "cannot infer parser for key f2.txt")
 }
 
+func TestNewObjectsUnknownConnectionValidation(t *testing.T) {
+	var config Config
+	json.Unmarshal([]byte(`{"pollInterval": "5s", "objects": [
+		{
+			"key": "huh.json",
+			"bucket": "someBucket",
+			"connection": "minio"
+		}
+	]}`), &config)
+
+	provider, err := New(context.Background(), &config, "test")
+	assert.ErrorContains(t, err, "object[0] references unknown connection minio")
+	assert.Nil(t, provider)
+}
+
+func TestNewObjectsConnections(t *testing.T) {
+	var config Config
+	json.Unmarshal([]byte(`{"pollInterval": "5s",
+	"connections": {
+		"minio": {
+			"endpoint": "http://minio.local:9000",
+			"region": "us-east-1",
+			"usePathStyle": true
+		},
+		"minioAlias": {
+			"endpoint": "http://minio.local:9000",
+			"region": "us-east-1",
+			"usePathStyle": true
+		},
+		"linode": {
+			"endpoint": "https://us-ord-1.linodeobjects.com",
+			"region": "us-ord-1"
+		}
+	},
+	"objects": [
+		{
+			"key": "aws.json",
+			"bucket": "someBucket"
+		},
+		{
+			"key": "minio.json",
+			"bucket": "someBucket",
+			"connection": "minio"
+		},
+		{
+			"key": "linode.json",
+			"bucket": "someBucket",
+			"connection": "linode"
+		},
+		{
+			"key": "minio2.json",
+			"bucket": "someBucket",
+			"connection": "minioAlias"
+		},
+		{
+			"key": "aws2.json",
+			"bucket": "someBucket"
+		}
+	]}`), &config)
+
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	require.Len(t, provider.retrievers, 5)
+
+	// Identical connections share a client
+	assert.Same(t, provider.retrievers[0].client, provider.retrievers[4].client)
+	assert.Same(t, provider.retrievers[1].client, provider.retrievers[3].client)
+	assert.NotSame(t, provider.retrievers[0].client, provider.retrievers[1].client)
+	assert.NotSame(t, provider.retrievers[1].client, provider.retrievers[2].client)
+
+	minioOpts := provider.retrievers[1].client.(*s3.Client).Options()
+	assert.Equal(t, "http://minio.local:9000", *minioOpts.BaseEndpoint)
+	assert.Equal(t, "us-east-1", minioOpts.Region)
+	assert.True(t, minioOpts.UsePathStyle)
+
+	linodeOpts := provider.retrievers[2].client.(*s3.Client).Options()
+	assert.Equal(t, "https://us-ord-1.linodeobjects.com", *linodeOpts.BaseEndpoint)
+	assert.Equal(t, "us-ord-1", linodeOpts.Region)
+	assert.False(t, linodeOpts.UsePathStyle)
+}
+
+func TestNewStaticCredentialsValidation(t *testing.T) {
+	var tests = []struct {
+		name string
+		config string
+		expectedError string
+	} {
+		{"key and key file", `"accessKeyId": "a", "accessKeyIdFile": "/a", "secretAccessKey": "b"`, "only one of accessKeyId or accessKeyIdFile can be set"},
+		{"secret and secret file", `"accessKeyId": "a", "secretAccessKey": "b", "secretAccessKeyFile": "/b"`, "only one of secretAccessKey or secretAccessKeyFile can be set"},
+		{"missing secret", `"accessKeyId": "a"`, "static credentials require secretAccessKey or secretAccessKeyFile"},
+		{"missing key", `"secretAccessKeyFile": "/b"`, "static credentials require accessKeyId or accessKeyIdFile"},
+		{"with credentials file", `"accessKeyId": "a", "secretAccessKey": "b", "credentialsFile": "/c"`, "credentialsFile cannot be combined with static credentials"},
+		{"in connection", `"connections": {"minio": {"accessKeyId": "a"}}`, "connection minio: static credentials require secretAccessKey"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5s", `+tt.config+`, "objects": [
+				{
+					"key": "huh.json",
+					"bucket": "someBucket"
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+func TestNewStaticCredentials(t *testing.T) {
+	var config Config
+	json.Unmarshal([]byte(`{"pollInterval": "5s",
+	"accessKeyId": "defaultKey",
+	"secretAccessKey": "defaultSecret",
+	"connections": {
+		"minio": {
+			"accessKeyId": "minioKey",
+			"secretAccessKey": "minioSecret",
+			"sessionToken": "minioToken"
+		}
+	},
+	"objects": [
+		{
+			"key": "aws.json",
+			"bucket": "someBucket"
+		},
+		{
+			"key": "minio.json",
+			"bucket": "someBucket",
+			"connection": "minio"
+		}
+	]}`), &config)
+
+	ctx := context.Background()
+	provider, err := New(ctx, &config, "test")
+	require.NoError(t, err)
+
+	creds, err := provider.retrievers[0].client.(*s3.Client).Options().Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "defaultKey", creds.AccessKeyID)
+	assert.Equal(t, "defaultSecret", creds.SecretAccessKey)
+
+	creds, err = provider.retrievers[1].client.(*s3.Client).Options().Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "minioKey", creds.AccessKeyID)
+	assert.Equal(t, "minioSecret", creds.SecretAccessKey)
+	assert.Equal(t, "minioToken", creds.SessionToken)
+}
+
 func TestMergedFiles(t *testing.T) {
 	var config Config
 	json.Unmarshal([]byte(`{"pollInterval": "1s", "objects": [
@@ -240,23 +405,26 @@ func TestMergedFiles(t *testing.T) {
 	provider.retrievers[1].client = s3Client
 	
 	now := time.Now()
-	s3Client.On("HeadObject", ctx, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	})
 	matchJson := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json"
+		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json" && arg.IfNoneMatch == nil
 	})
 	matchYaml := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml"
+		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml" && arg.IfNoneMatch == nil
+	})
+	matchConditional := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return arg.IfNoneMatch != nil
 	})
 	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("yaml1"),
 		Body: io.NopCloser(bytes.NewReader([]byte(yaml1))),
 	}, nil)
 	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("json1"),
 		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
 	}, nil)
+	s3Client.On("GetObject", mock.Anything, matchConditional, mock.Anything).Return(nil, newNotModifiedError())
 
 	provider.Init()
 
@@ -281,7 +449,7 @@ func TestMergedFiles(t *testing.T) {
 
 	s3Client.AssertCalled(t, "GetObject", mock.Anything, matchJson, mock.Anything)
 	s3Client.AssertCalled(t, "GetObject", mock.Anything, matchYaml, mock.Anything)
-	s3Client.AssertNumberOfCalls(t, "GetObject", 2)
+	s3Client.AssertNotCalled(t, "GetObject", mock.Anything, matchConditional, mock.Anything)
 }
 
 func TestMergedFilesOverwrite(t *testing.T) {
@@ -316,40 +484,38 @@ func TestMergedFilesOverwrite(t *testing.T) {
 	provider.retrievers[1].client = s3Client
 	
 	now := time.Now()
-	next := now.Add(time.Duration(5) * time.Second)
-	matchJsonHead := mock.MatchedBy(func (arg *s3.HeadObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json"
+	matchJson := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json" && arg.IfNoneMatch == nil
 	})
-	matchYamlHead := mock.MatchedBy(func (arg *s3.HeadObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml"
+	matchJsonSinceFirst := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json" && arg.IfNoneMatch != nil && *arg.IfNoneMatch == "json1"
 	})
-	s3Client.On("HeadObject", mock.Anything, matchJsonHead, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, nil).Once()
-	s3Client.On("HeadObject", mock.Anything, matchJsonHead, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &next,
-	}, nil)
-	s3Client.On("HeadObject", mock.Anything, matchYamlHead, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, nil)
-	matchJson := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json"
+	matchJsonSinceSecond := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json" && arg.IfNoneMatch != nil && *arg.IfNoneMatch == "json2"
 	})
 	matchYaml := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml"
+		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml" && arg.IfNoneMatch == nil
+	})
+	matchYamlConditional := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml" && arg.IfNoneMatch != nil
 	})
 	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("yaml1"),
 		Body: io.NopCloser(bytes.NewReader([]byte(yaml1))),
 	}, nil)
+	s3Client.On("GetObject", mock.Anything, matchYamlConditional, mock.Anything).Return(nil, newNotModifiedError())
 	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("json1"),
 		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
 	}, nil).Once()
-	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
+	s3Client.On("GetObject", mock.Anything, matchJsonSinceFirst, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("json2"),
 		Body: io.NopCloser(bytes.NewReader([]byte(json2))),
 	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchJsonSinceSecond, mock.Anything).Return(nil, newNotModifiedError())
 
 	provider.Init()
 
@@ -407,39 +573,33 @@ func TestMergedFilesNoChange(t *testing.T) {
 	provider.retrievers[1].client = s3Client
 	
 	now := time.Now()
-	matchJsonHead := mock.MatchedBy(func (arg *s3.HeadObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json"
-	})
-	matchYamlHead := mock.MatchedBy(func (arg *s3.HeadObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml"
-	})
-	s3Client.On("HeadObject", mock.Anything, matchJsonHead, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, nil)
-	s3Client.On("HeadObject", mock.Anything, matchYamlHead, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, nil)
 	matchJson := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json"
+		return *arg.Bucket == "someBucket" && *arg.Key == "huh.json" && arg.IfNoneMatch == nil
 	})
 	matchYaml := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
-		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml"
+		return *arg.Bucket == "someBucket" && *arg.Key == "f.yml" && arg.IfNoneMatch == nil
+	})
+	matchConditional := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return arg.IfNoneMatch != nil
 	})
 	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("yaml1"),
 		Body: io.NopCloser(bytes.NewReader([]byte(yaml1))),
 	}, nil)
 	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String("json1"),
 		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
 	}, nil)
+	s3Client.On("GetObject", mock.Anything, matchConditional, mock.Anything).Return(nil, newNotModifiedError())
 
 	provider.retrievers[0].data = &ConfigData{
-		lastModifiedAt: now,
+		etag: "json1",
 		json: make(map[string]interface{}),
 	}
 	provider.retrievers[1].data = &ConfigData{
-		lastModifiedAt: now,
+		etag: "yaml1",
 		json: make(map[string]interface{}),
 	}
 
@@ -464,3 +624,745 @@ func TestMergedFilesNoChange(t *testing.T) {
 		close(cfgChan)
 	}
 }
+
+func TestNewObjectsPrefixValidation(t *testing.T) {
+	var tests = []struct {
+		name string
+		object string
+		expectedError string
+	} {
+		{"key and prefix", `"key": "f.yaml", "prefix": "dynamic/"`, "object[0] cannot have both a key and a prefix"},
+		{"glob with key", `"key": "f.yaml", "glob": "*.yaml"`, "object[0] can only use glob or regex with a prefix"},
+		{"regex with key", `"key": "f.yaml", "regex": "yaml$"`, "object[0] can only use glob or regex with a prefix"},
+		{"bad glob", `"prefix": "dynamic/", "glob": "[.yaml"`, "object[0] has invalid glob [.yaml"},
+		{"bad regex", `"prefix": "dynamic/", "regex": "(yaml"`, "object[0] has invalid regex (yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5s", "objects": [
+				{
+					"bucket": "someBucket",
+					`+tt.object+`
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+func TestMergedFilesDiscovered(t *testing.T) {
+	var config Config
+	json.Unmarshal([]byte(`{"pollInterval": "1s", "objects": [
+		{
+			"prefix": "dynamic/",
+			"bucket": "someBucket"
+		},
+		{
+			"key": "f.yml",
+			"bucket": "someBucket"
+		}
+	]}`), &config)
+
+	ctx := context.Background()
+	provider, err := New(ctx, &config, "test")
+	require.Nil(t, err)
+	// Only the static object is known until the prefix is listed
+	require.Len(t, provider.retrievers, 1)
+
+	s3Client := newMockS3Client()
+	provider.sources[0].(*S3PrefixDiscoverer).client = s3Client
+	provider.retrievers[0].client = s3Client
+
+	now := time.Now()
+	s3Client.On("ListObjectsV2", mock.Anything, mock.Anything, mock.Anything).Return(newListPage(
+		"dynamic/b.json",
+		"dynamic/a.json",
+	), nil).Once()
+	s3Client.On("ListObjectsV2", mock.Anything, mock.Anything, mock.Anything).Return(newListPage(
+		"dynamic/a.json",
+	), nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "dynamic/a.json" && arg.IfNoneMatch == nil
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "dynamic/b.json" && arg.IfNoneMatch == nil
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json2"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json2))),
+	}, nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "f.yml" && arg.IfNoneMatch == nil
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("yaml1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(yaml1))),
+	}, nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return arg.IfNoneMatch != nil
+	}), mock.Anything).Return(nil, newNotModifiedError())
+
+	// Discovered objects merge in key order ahead of the objects declared after them
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ := json.Marshal(map[string]interface{} {
+		"tls": map[string]interface{} {
+			"certificates": []map[string]interface{} {
+				{"certFile": "certpath", "keyFile": "keypath"},
+				{"certFile": "certpath2", "keyFile": "keypath2"},
+				{"certFile": "certpath2", "keyFile": "keypath2"},
+				{"certFile": "certpath", "keyFile": "keypath"},
+				{"certFile": "/path/to/domain.cert", "keyFile": "/path/to/domain.key"},
+				{"certFile": "/path/to/other-domain.cert", "keyFile": "/path/to/other-domain.key"},
+			},
+			"additional": "somevalue",
+			"newAdditional": "diffvalue",
+		},
+	})
+	assert.Equal(t, string(expBytes), string(received))
+	require.Len(t, provider.retrievers, 3)
+
+	// Removing an object re-emits the configuration without it
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ = json.Marshal(json1AndYaml1)
+	assert.Equal(t, string(expBytes), string(received))
+	require.Len(t, provider.retrievers, 2)
+
+	// Nothing changed
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.Nil(t, received)
+}
+
+func TestLastKnownGoodKeepsFailedObject(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, `"keepLastKnownGood": true`, jsonObject, yamlObject)
+
+	now := time.Now()
+	matchJson := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "huh.json"
+	})
+	matchYaml := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "f.yml"
+	})
+	// first poll succeeds
+	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("yaml1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(yaml1))),
+	}, nil).Once()
+	// second poll the yaml fails and the json is unchanged
+	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(nil, newNotModifiedError()).Once()
+	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(nil, errors.New("Oh no!")).Once()
+	// third poll the yaml still fails and the json changes
+	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json2"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json2))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(nil, errors.New("Oh no!")).Once()
+
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ := json.Marshal(json1AndYaml1)
+	assert.Equal(t, string(expBytes), string(received))
+
+	// A failure alone does not re-emit anything
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.Nil(t, received)
+
+	// A healthy change re-emits with the failed object's last good data
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ = json.Marshal(json2AndYaml1)
+	assert.Equal(t, string(expBytes), string(received))
+}
+
+func TestLastKnownGoodInitialFailure(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, `"keepLastKnownGood": true`, jsonObject, yamlObject)
+
+	now := time.Now()
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "huh.json"
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "f.yml"
+	}), mock.Anything).Return(nil, errors.New("Oh no!"))
+
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	var parsed map[string]interface{}
+	require.NoError(t, json.Unmarshal(received, &parsed))
+	assert.Equal(t, "somevalue", parsed["tls"].(map[string]interface{})["additional"])
+	assert.Len(t, parsed["tls"].(map[string]interface{})["certificates"], 2)
+}
+
+func TestWithoutLastKnownGoodFails(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, "", jsonObject, yamlObject)
+
+	now := time.Now()
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "huh.json"
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "f.yml"
+	}), mock.Anything).Return(nil, errors.New("Oh no!"))
+
+	_, err := provider.getConfiguration(ctx, nil)
+	require.ErrorContains(t, err, "Oh no!")
+}
+
+func TestCacheWrittenOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	provider, s3Client := newTestProvider(t, `"cacheDir": "`+cacheDir+`"`, jsonObject)
+
+	now := time.Now()
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil)
+
+	cfgChan := make(chan json.Marshaler, 1)
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	received, err := (<-cfgChan).MarshalJSON()
+	require.NoError(t, err)
+
+	cached, err := provider.cache.Load()
+	require.NoError(t, err)
+	require.NotNil(t, cached)
+	assert.Equal(t, string(received), string(cached.Configuration))
+	assert.Equal(t, map[string]string{"someBucket/huh.json": "json1"}, cached.ETags)
+}
+
+func TestCacheProvidedOnInitialFailure(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	provider, s3Client := newTestProvider(t, `"cacheDir": "`+cacheDir+`"`, jsonObject)
+	require.NoError(t, provider.cache.Save([]byte(`{"http":{"routers":{}}}`), map[string]string{"someBucket/huh.json": "json0"}))
+
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!"))
+
+	cfgChan := make(chan json.Marshaler, 1)
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	received, err := (<-cfgChan).MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, `{"http":{"routers":{}}}`, string(received))
+
+	// Later failures are reported as usual
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	_, err = (<-cfgChan).MarshalJSON()
+	require.ErrorContains(t, err, "Oh no!")
+}
+
+func TestCacheMissingOnInitialFailure(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, `"cacheDir": "`+t.TempDir()+`"`, jsonObject)
+
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!"))
+
+	cfgChan := make(chan json.Marshaler, 1)
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	_, err := (<-cfgChan).MarshalJSON()
+	require.ErrorContains(t, err, "Oh no!")
+}
+
+func TestCacheKeptOnInitialPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, `"keepLastKnownGood": true, "cacheDir": "`+t.TempDir()+`"`, numberedObjects(2)...)
+	require.NoError(t, provider.cache.Save([]byte(`{"http":{"routers":{}}}`), map[string]string{"someBucket/obj0.json": "json0"}))
+
+	s3Client.On("GetObject", mock.Anything, matchKey("obj0.json"), mock.Anything).Return(objectOutput("json1", json1), nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchKey("obj1.json"), mock.Anything).Return(nil, errors.New("Oh no!")).Twice()
+
+	cfgChan := make(chan json.Marshaler, 1)
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	received, err := (<-cfgChan).MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, `{"http":{"routers":{}}}`, string(received))
+
+	// A change while an object still fails does not replace the cached configuration
+	s3Client.On("GetObject", mock.Anything, matchKey("obj0.json"), mock.Anything).Return(objectOutput("json3", `{"http": {}}`), nil).Once()
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	assert.Empty(t, cfgChan)
+	cached, err := provider.cache.Load()
+	require.NoError(t, err)
+	assert.Equal(t, `{"http":{"routers":{}}}`, string(cached.Configuration))
+
+	// Once every object is retrieved the merged configuration is provided and cached, even though the object
+	// that changed was not modified since
+	s3Client.On("GetObject", mock.Anything, matchKey("obj0.json"), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	s3Client.On("GetObject", mock.Anything, matchKey("obj1.json"), mock.Anything).Return(objectOutput("json2", json2), nil).Once()
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	received, err = (<-cfgChan).MarshalJSON()
+	require.NoError(t, err)
+	expected := `{"http":{},"tls":{"certificates":[{"certFile":"certpath2","keyFile":"keypath2"},{"certFile":"certpath","keyFile":"keypath"}],"newAdditional":"diffvalue"}}`
+	assert.Equal(t, expected, string(received))
+	cached, err = provider.cache.Load()
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(cached.Configuration))
+	assert.Equal(t, map[string]string{"someBucket/obj0.json": "json3", "someBucket/obj1.json": "json2"}, cached.ETags)
+	s3Client.AssertExpectations(t)
+}
+
+// A clock that reports each requested wait and only fires when told to
+type fakeClock struct {
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		waits: make(chan time.Duration, 10),
+		fire:  make(chan time.Time),
+	}
+}
+
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.waits <- d
+	return clock.fire
+}
+
+func TestNewPollScheduleValidation(t *testing.T) {
+	var tests = []struct {
+		name string
+		config string
+		expectedError string
+	} {
+		{"bad jitter", `"pollJitter": "5"`, "invalid poll jitter"},
+		{"negative jitter", `"pollJitter": "-5s"`, "poll jitter cannot be negative"},
+		{"max without initial", `"retryMaxInterval": "5s"`, "retryMaxInterval and retryMultiplier require a retryInitialInterval"},
+		{"multiplier without initial", `"retryMultiplier": 3`, "retryMaxInterval and retryMultiplier require a retryInitialInterval"},
+		{"bad initial", `"retryInitialInterval": "5"`, "invalid retry initial interval"},
+		{"zero initial", `"retryInitialInterval": "0s"`, "retry initial interval must be greater than 0"},
+		{"bad max", `"retryInitialInterval": "5s", "retryMaxInterval": "5"`, "invalid retry max interval"},
+		{"max less than initial", `"retryInitialInterval": "5s", "retryMaxInterval": "1s"`, "retry max interval cannot be less than the retry initial interval"},
+		{"initial more than poll interval", `"retryInitialInterval": "1h"`, "retry max interval cannot be less than the retry initial interval"},
+		{"small multiplier", `"retryInitialInterval": "5s", "retryMultiplier": 0.5`, "retry multiplier must be at least 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", `+tt.config+`, "objects": [
+				{
+					"key": "huh.json",
+					"bucket": "someBucket"
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+func TestPollBacksOffOnFailure(t *testing.T) {
+	var config Config
+	json.Unmarshal([]byte(`{
+		"pollInterval": "1m",
+		"pollJitter": "10s",
+		"retryInitialInterval": "1s",
+		"retryMaxInterval": "5s",
+		"objects": [
+			{
+				"key": "huh.json",
+				"bucket": "someBucket"
+			}
+		]
+	}`), &config)
+
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	clock := newFakeClock()
+	provider.clock = clock
+	provider.schedule.random = func() float64 { return 0.5 }
+
+	s3Client := newMockS3Client()
+	provider.retrievers[0].client = s3Client
+	now := time.Now()
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!")).Times(4)
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!"))
+
+	cfgChan := make(chan json.Marshaler, 10)
+	require.NoError(t, provider.Provide(cfgChan))
+	t.Cleanup(func() {
+		provider.Stop()
+	})
+
+	expectedWaits := []time.Duration{
+		// failures back off up to the max, waiting between half and all of each delay
+		750 * time.Millisecond,
+		1500 * time.Millisecond,
+		3 * time.Second,
+		3750 * time.Millisecond,
+		// success waits the jittered poll interval
+		time.Minute + 5*time.Second,
+		// the next failure starts the backoff over
+		750 * time.Millisecond,
+	}
+	for _, expected := range expectedWaits {
+		select {
+		case wait := <-clock.waits:
+			assert.Equal(t, expected, wait)
+		case <-time.After(3 * time.Second):
+			require.FailNow(t, "timed out waiting for the next poll")
+		}
+		clock.fire <- time.Now()
+	}
+}
+
+// Json objects obj0.json up to obj<n-1>.json
+func numberedObjects(n int) []string {
+	objects := make([]string, n)
+	for i := range objects {
+		objects[i] = fmt.Sprintf(`{"key": "obj%d.json", "bucket": "someBucket"}`, i)
+	}
+	return objects
+}
+
+func TestNewConcurrencyValidation(t *testing.T) {
+	var tests = []struct {
+		name string
+		config string
+		expectedError string
+	} {
+		{"negative concurrency", `"maxConcurrentRequests": -1`, "max concurrent requests must be greater than 0"},
+		{"bad timeout", `"requestTimeout": "5"`, "invalid request timeout"},
+		{"zero timeout", `"requestTimeout": "0s"`, "request timeout must be greater than 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", `+tt.config+`, "objects": [
+				{
+					"key": "huh.json",
+					"bucket": "someBucket"
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+func TestRetrieveBoundedConcurrency(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, `"maxConcurrentRequests": 3`, numberedObjects(12)...)
+	assert.Equal(t, 3, provider.maxConcurrentRequests)
+
+	var inFlight, maxInFlight int32
+	now := time.Now()
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		current := atomic.AddInt32(&inFlight, 1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+	}).Return(func(*s3.GetObjectInput) *s3.GetObjectOutput {
+		return &s3.GetObjectOutput{
+			LastModified: &now,
+			ETag: aws.String("json1"),
+			Body: io.NopCloser(bytes.NewReader([]byte(`{"http": {}}`))),
+		}
+	}, nil)
+
+	results := provider.retrieveAll(ctx, nil)
+	require.Len(t, results, 12)
+	s3Client.AssertNumberOfCalls(t, "GetObject", 12)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&maxInFlight))
+	for i, result := range results {
+		assert.NoError(t, result.err, "object %d", i)
+		assert.True(t, result.changed, "object %d", i)
+		require.NotNil(t, provider.retrievers[i].data, "object %d", i)
+		assert.Equal(t, map[string]interface{}{"http": map[string]interface{}{}}, provider.retrievers[i].data.json, "object %d", i)
+	}
+}
+
+func TestRetrieveConcurrentMergeOrder(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, "", numberedObjects(2)...)
+
+	now := time.Now()
+	// The first object finishes last
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "obj0.json"
+	}), mock.Anything).Run(func(args mock.Arguments) {
+		time.Sleep(50 * time.Millisecond)
+	}).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil)
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "obj1.json"
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json2"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json2))),
+	}, nil)
+
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ := json.Marshal(map[string]interface{} {
+		"tls": map[string]interface{} {
+			"certificates": []map[string]interface{} {
+				{"certFile": "certpath", "keyFile": "keypath"},
+				{"certFile": "certpath2", "keyFile": "keypath2"},
+				{"certFile": "certpath2", "keyFile": "keypath2"},
+				{"certFile": "certpath", "keyFile": "keypath"},
+			},
+			"additional": "somevalue",
+			"newAdditional": "diffvalue",
+		},
+	})
+	assert.Equal(t, string(expBytes), string(received))
+}
+
+func TestChangeKeptAfterErroredPoll(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, "", numberedObjects(2)...)
+
+	s3Client.On("GetObject", mock.Anything, matchKey("obj0.json"), mock.Anything).Return(objectOutput("json1", json1), nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchKey("obj1.json"), mock.Anything).Return(objectOutput("json2", json2), nil).Once()
+	_, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+
+	// The first object changes in the same poll that the second fails
+	s3Client.On("GetObject", mock.Anything, matchKey("obj0.json"), mock.Anything).Return(objectOutput("json3", `{"http": {}}`), nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchKey("obj1.json"), mock.Anything).Return(nil, errors.New("Oh no!")).Once()
+	_, err = provider.getConfiguration(ctx, nil)
+	require.ErrorContains(t, err, "Oh no!")
+
+	// Nothing changes after that, but the change that was never sent is
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(nil, newNotModifiedError())
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `{"http":{},"tls":{"certificates":[{"certFile":"certpath2","keyFile":"keypath2"},{"certFile":"certpath","keyFile":"keypath"}],"newAdditional":"diffvalue"}}`, string(received))
+
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.Nil(t, received)
+}
+
+func TestRetrieveRequestTimeout(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, `"requestTimeout": "50ms"`, numberedObjects(1)...)
+
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return(nil, context.DeadlineExceeded)
+
+	start := time.Now()
+	_, err := provider.getConfiguration(ctx, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestMergedFilesStrategy(t *testing.T) {
+	var tests = []struct {
+		name           string
+		globalStrategy string
+		objectStrategy string
+		certificates   []map[string]interface{}
+	}{
+		{"default appends", "", "", []map[string]interface{}{
+			{"certFile": "certpath", "keyFile": "keypath"},
+			{"certFile": "certpath2", "keyFile": "keypath2"},
+			{"certFile": "certpath2", "keyFile": "keypath2"},
+			{"certFile": "certpath", "keyFile": "keypath"},
+		}},
+		{"global dedupe", "deep-merge-with-slice-dedupe", "", []map[string]interface{}{
+			{"certFile": "certpath", "keyFile": "keypath"},
+			{"certFile": "certpath2", "keyFile": "keypath2"},
+		}},
+		{"object replace", "deep-merge-with-slice-dedupe", "replace", []map[string]interface{}{
+			{"certFile": "certpath2", "keyFile": "keypath2"},
+			{"certFile": "certpath", "keyFile": "keypath"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "1s", "mergeStrategy": "`+tt.globalStrategy+`", "objects": [
+				{
+					"key": "huh.json",
+					"bucket": "someBucket"
+				},
+				{
+					"key": "other.json",
+					"bucket": "someBucket",
+					"mergeStrategy": "`+tt.objectStrategy+`"
+				}
+			]}`), &config)
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			provider, err := New(ctx, &config, "test")
+			require.NoError(t, err)
+			s3Client := newMockS3Client()
+			provider.retrievers[0].client = s3Client
+			provider.retrievers[1].client = s3Client
+			now := time.Now()
+			s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+				return *arg.Key == "huh.json"
+			}), mock.Anything).Return(&s3.GetObjectOutput{
+				LastModified: &now,
+				ETag: aws.String("json1"),
+				Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+			}, nil)
+			s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+				return *arg.Key == "other.json"
+			}), mock.Anything).Return(&s3.GetObjectOutput{
+				LastModified: &now,
+				ETag: aws.String("json2"),
+				Body: io.NopCloser(bytes.NewReader([]byte(json2))),
+			}, nil)
+
+			received, err := provider.getConfiguration(ctx, nil)
+			require.NoError(t, err)
+			expBytes, _ := json.Marshal(map[string]interface{}{
+				"tls": map[string]interface{}{
+					"certificates":  tt.certificates,
+					"additional":    "somevalue",
+					"newAdditional": "diffvalue",
+				},
+			})
+			assert.Equal(t, string(expBytes), string(received))
+		})
+	}
+}
+
+func TestNewMergeStrategyValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{"global", `"mergeStrategy": "union", "objects": [{"key": "huh.json", "bucket": "someBucket"}]`, `"union" is not a valid merge strategy`},
+		{"object", `"objects": [{"key": "huh.json", "bucket": "someBucket", "mergeStrategy": "union"}]`, `object[0] "union" is not a valid merge strategy`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", `+tt.config+`}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+func TestOptionalObjectRemoved(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, "", jsonObject, `{"key": "f.yml", "bucket": "someBucket", "optional": true}`)
+
+	now := time.Now()
+	matchJson := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "huh.json"
+	})
+	matchYaml := mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "f.yml"
+	})
+	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("json1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(json1))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag: aws.String("yaml1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(yaml1))),
+	}, nil).Once()
+	// The yaml is deleted
+	s3Client.On("GetObject", mock.Anything, matchJson, mock.Anything).Return(nil, newNotModifiedError())
+	s3Client.On("GetObject", mock.Anything, matchYaml, mock.Anything).Return(nil, &types.NoSuchKey{})
+
+	received, err := provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	expBytes, _ := json.Marshal(json1AndYaml1)
+	assert.Equal(t, string(expBytes), string(received))
+
+	// The configuration is re-emitted without the yaml
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, json1, string(received))
+	assert.Equal(t, 0, provider.consecutiveFailures)
+
+	// Still missing is not a change
+	received, err = provider.getConfiguration(ctx, nil)
+	require.NoError(t, err)
+	assert.Nil(t, received)
+}
+
+func TestRequiredObjectRemoved(t *testing.T) {
+	ctx := context.Background()
+	provider, s3Client := newTestProvider(t, "", jsonObject, yamlObject)
+
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "huh.json"
+	}), mock.Anything).Return(nil, newNotModifiedError())
+	s3Client.On("GetObject", mock.Anything, mock.MatchedBy(func (arg *s3.GetObjectInput) (bool) {
+		return *arg.Key == "f.yml"
+	}), mock.Anything).Return(nil, &types.NoSuchKey{})
+
+	_, err := provider.getConfiguration(ctx, nil)
+	assert.ErrorContains(t, err, "required object someBucket/f.yml does not exist")
+}
+
+func TestNewOptionalPrefixValidation(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [{"prefix": "dynamic/", "bucket": "someBucket", "optional": true}]}`), &config)
+	require.NoError(t, err)
+
+	provider, err := New(context.Background(), &config, "test")
+	assert.ErrorContains(t, err, "object[0] cannot be optional with a prefix")
+	assert.Nil(t, provider)
+}
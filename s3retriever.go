@@ -1,16 +1,24 @@
 package s3provider
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"gopkg.in/yaml.v3"
 )
 
@@ -20,11 +28,13 @@ const (
 	Unknown Parser = iota
 	Json
 	Yaml
+	Toml
 )
 
 var ValidParsersFromString = map[string]Parser{
 	"json": Json,
 	"yaml": Yaml,
+	"toml": Toml,
 }
 
 func ParseParser(s string) (Parser, error) {
@@ -36,17 +46,35 @@ func ParseParser(s string) (Parser, error) {
 	return Parser(value), nil
 }
 
+// Determines the parser for a key from its file extension. A compression extension is skipped, so
+// "routes.yaml.gz" is Yaml
+func InferParser(key string) (Parser, error) {
+	ext := filepath.Ext(key)
+	if compressionFromKey(key) != Uncompressed {
+		ext = filepath.Ext(strings.TrimSuffix(key, ext))
+	}
+	switch ext {
+	case ".yaml", ".yml":
+		return Yaml, nil
+	case ".json":
+		return Json, nil
+	case ".toml":
+		return Toml, nil
+	default:
+		return Unknown, fmt.Errorf("cannot infer parser for key %s. Must have a known extension or explicitly set parser", key)
+	}
+}
+
 // represents data retrieved from config object in a bucket
 type ConfigData struct {
 	// The unmarshalled json struct
 	json map[string]interface{}
-	// the date at which it was last updated
-	lastModifiedAt time.Time
+	// The ETag of the object that the data was parsed from
+	etag string
 }
 
 type MinS3Api interface {
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
-	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 }
 
 type RetrieverConfig struct {
@@ -56,6 +84,37 @@ type RetrieverConfig struct {
 	Key string
 	// The way to parse the config object
 	Parser Parser
+	// Renders the object before it is parsed. Not rendered if nil
+	Template *Templater
+	// The most bytes that a gzip or zstd compressed object can decompress to. Defaults to 64MiB
+	MaxDecompressedSize int64
+	// If the object does not exist, drop its data instead of failing
+	Optional bool
+	// The version of the object to retrieve. The latest version if empty or "latest"
+	VersionID string
+	// The key of an object in the same bucket whose contents are the version of this object to retrieve
+	VersionPointer string
+	// If set, the (quoted) ETag that the object must have. Any other ETag is an ErrETagMismatch
+	ExpectedETag string
+	// The customer provided key that the object and its version pointer are encrypted with, if any
+	SSECustomerKey *SSECustomerKey
+	// Decrypts the object if it is age or sops encrypted. Not decrypted if nil
+	Decrypter *Decrypter
+	ObjectOptions
+}
+
+// How an object's data is changed and merged into the objects before it
+type ObjectOptions struct {
+	// How the object is merged into the objects before it. Empty is the same as MergeAppend
+	MergeStrategy MergeStrategy
+	// Prepended to the names of the routers, services and middlewares that the object defines
+	NamePrefix string
+	// If not empty, the only paths (split on ".") that the object may define
+	AllowedPaths [][]string
+	// Paths (split on ".") that the object may not define
+	DeniedPaths [][]string
+	// What to do when the object defines a path that it may not
+	PathViolation PathViolationAction
 }
 
 type S3ObjectRetriever struct {
@@ -64,6 +123,8 @@ type S3ObjectRetriever struct {
 	client MinS3Api
 	// Data that was previously retrieved
 	data *ConfigData
+	// The version pointer object as it was last read
+	pointer *versionPointerData
 }
 
 type CredentialsGetter func(ctx context.Context) (aws.Credentials, error)
@@ -82,70 +143,289 @@ func NewS3ObjectRetriever(client MinS3Api, config RetrieverConfig) (*S3ObjectRet
 	}
 }
 
-// Indicates that the last retrieved data is no longer in sync with what is in the bucket
-func (retriever *S3ObjectRetriever) HasChanged(ctx context.Context) (bool, error) {
-	if retriever.data == nil {
-		return true, nil
+// Retrieves the object if it no longer matches the last retrieved data and replaces the data on this retriever.
+// Uses a conditional GET on the previous ETag so that an unchanged object costs a single 304 round trip.
+// If an optional object does not exist its data is dropped. Returns true if the data was replaced or dropped.
+func (retriever *S3ObjectRetriever) Retrieve(ctx context.Context) (bool, error) {
+	if retriever.atExpectedETag() {
+		// The object was already retrieved at the only ETag it can have
+		return false, nil
+	}
+	input, err := retriever.getObjectInput(ctx)
+	if err != nil {
+		return false, err
 	}
 
-	resp, err := retriever.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(retriever.Bucket),
-		Key:    aws.String(retriever.Key),
-	})
-	// TODO - do some error handling
+	// Get the object from S3
+	output, err := retriever.client.GetObject(ctx, input)
+	if err != nil {
+		return retriever.handleGetError(err)
+	}
+	defer output.Body.Close()
+
+	etag := aws.ToString(output.ETag)
+	if unchanged, err := retriever.unchangedAt(etag); err != nil || unchanged {
+		return false, err
+	}
+
+	decompressed, err := retriever.decompressBody(output)
+	if err != nil {
+		return false, err
+	}
+	defer decompressed.Close()
+
+	body, err := retriever.decryptAndRender(decompressed)
+	if err != nil {
+		return false, err
+	}
+	parsed, err := retriever.parse(body)
 	if err != nil {
-		log.Printf("unable to get attributes for %s/%s: %v", retriever.Bucket, retriever.Key, err)
 		return false, err
 	}
 
-	return resp.LastModified.After(retriever.data.lastModifiedAt), nil
+	retriever.data = &ConfigData{
+		json: parsed,
+		etag: etag,
+	}
+	return true, nil
 }
 
-// Replaces the data on this 
-func (retriever *S3ObjectRetriever) Retrieve(ctx context.Context) error {
-	// Get the object from S3
-	output, err := retriever.client.GetObject(ctx, &s3.GetObjectInput{
+// Whether the object must have an ETag and the last retrieved data is at it
+func (retriever *S3ObjectRetriever) atExpectedETag() bool {
+	return retriever.ExpectedETag != "" && retriever.data != nil && retriever.data.etag == retriever.ExpectedETag
+}
+
+// Creates the request for the object's pinned version, conditional on its ETag
+func (retriever *S3ObjectRetriever) getObjectInput(ctx context.Context) (*s3.GetObjectInput, error) {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(retriever.Bucket),
 		Key:    aws.String(retriever.Key),
-	})
+	}
+	// An object pinned to an ETag is only requested at that ETag, which the data is not at
+	if retriever.ExpectedETag == "" && retriever.data != nil && retriever.data.etag != "" {
+		input.IfNoneMatch = aws.String(retriever.data.etag)
+	}
+	versionID, err := retriever.resolveVersion(ctx)
 	if err != nil {
-		log.Printf("failed to get object: %v", err)
-		return err
+		log.Printf("failed to resolve the version of %s/%s: %v", retriever.Bucket, retriever.Key, err)
+		return nil, err
 	}
-	defer output.Body.Close()
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	if retriever.ExpectedETag != "" {
+		input.IfMatch = aws.String(retriever.ExpectedETag)
+	}
+	if retriever.SSECustomerKey != nil {
+		if err := retriever.SSECustomerKey.apply(input); err != nil {
+			log.Printf("failed to get the sse customer key for %s/%s: %v", retriever.Bucket, retriever.Key, err)
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+// Handles a failed GET of the object, which is not a failure if the object has not changed or is optional
+func (retriever *S3ObjectRetriever) handleGetError(err error) (bool, error) {
+	if isNotModified(err) {
+		// The data is still the object's current data, which is not at the ETag it must be
+		if retriever.ExpectedETag != "" {
+			return false, retriever.etagMismatch()
+		}
+		return false, nil
+	}
+	if isPreconditionFailed(err) {
+		return false, retriever.etagMismatch()
+	}
+	if !isNotFound(err) {
+		log.Printf("failed to get object %s/%s: %v", retriever.Bucket, retriever.Key, err)
+		return false, err
+	}
+	if !retriever.Optional {
+		log.Printf("required object %s/%s does not exist", retriever.Bucket, retriever.Key)
+		return false, fmt.Errorf("required object %s/%s does not exist: %w", retriever.Bucket, retriever.Key, err)
+	}
+	if retriever.data == nil {
+		return false, nil
+	}
+	log.Printf("optional object %s/%s no longer exists, dropping its data", retriever.Bucket, retriever.Key)
+	retriever.data = nil
+	return true, nil
+}
+
+// Checks the ETag of the retrieved object, and returns whether it is the ETag of the last retrieved data
+func (retriever *S3ObjectRetriever) unchangedAt(etag string) (bool, error) {
+	// Some s3 compatible stores ignore If-Match
+	if retriever.ExpectedETag != "" && etag != retriever.ExpectedETag {
+		return false, retriever.etagMismatch()
+	}
+	// Some s3 compatible stores ignore If-None-Match so we double check the returned tag
+	return retriever.data != nil && etag != "" && etag == retriever.data.etag, nil
+}
+
+// Returns the object's body, decompressed if it is gzip or zstd compressed
+func (retriever *S3ObjectRetriever) decompressBody(output *s3.GetObjectOutput) (io.ReadCloser, error) {
+	maxSize := retriever.MaxDecompressedSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxDecompressedSize
+	}
+	decompressed, err := decompress(output.Body, detectCompression(aws.ToString(output.ContentEncoding), retriever.Key), maxSize)
+	if err != nil {
+		log.Printf("failed to decompress %s/%s: %v", retriever.Bucket, retriever.Key, err)
+		return nil, fmt.Errorf("failed to decompress %s/%s: %w", retriever.Bucket, retriever.Key, err)
+	}
+	return decompressed, nil
+}
+
+// Decrypts and then renders the object's body, if the retriever is configured to
+func (retriever *S3ObjectRetriever) decryptAndRender(body io.Reader) (io.Reader, error) {
+	if retriever.Decrypter != nil {
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			log.Printf("failed to read object %s/%s: %v", retriever.Bucket, retriever.Key, err)
+			return nil, err
+		}
+		decrypted, err := retriever.Decrypter.Decrypt(raw, retriever.Parser)
+		if err != nil {
+			log.Printf("failed to decrypt %s/%s: %v", retriever.Bucket, retriever.Key, err)
+			return nil, fmt.Errorf("failed to decrypt %s/%s: %w", retriever.Bucket, retriever.Key, err)
+		}
+		body = bytes.NewReader(decrypted)
+	}
+	// Rendered after decrypting, since sops checks that the values it encrypted were not changed
+	if retriever.Template != nil {
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			log.Printf("failed to read object %s/%s: %v", retriever.Bucket, retriever.Key, err)
+			return nil, err
+		}
+		rendered, err := retriever.Template.Render(raw)
+		if err != nil {
+			log.Printf("failed to render template for %s/%s: %v", retriever.Bucket, retriever.Key, err)
+			return nil, fmt.Errorf("failed to render template for %s/%s: %w", retriever.Bucket, retriever.Key, err)
+		}
+		body = bytes.NewReader(rendered)
+	}
+	return body, nil
+}
 
-	// Serialize the object
+// Serializes the object with the retriever's parser
+func (retriever *S3ObjectRetriever) parse(body io.Reader) (map[string]interface{}, error) {
+	var parsed map[string]interface{}
 	switch retriever.Parser {
 	case Json:
-		var jsonMap map[string]interface{}
-		if err := json.NewDecoder(output.Body).Decode(&jsonMap); err != nil {
+		if err := json.NewDecoder(body).Decode(&parsed); err != nil {
 			log.Printf("failed to decode JSON for %s/%s: %v", retriever.Bucket, retriever.Key, err)
-			return err
-		}
-		retriever.data = &ConfigData{
-			json:           jsonMap,
-			lastModifiedAt: *output.LastModified,
+			return nil, err
 		}
 	case Yaml:
-		// var yamlMap map[string]interface{}
-		var node yaml.Node
-		if err := yaml.NewDecoder(output.Body).Decode(&node); err != nil {
+		var err error
+		parsed, err = decodeYamlDocuments(body, retriever.MergeStrategy)
+		if err != nil {
 			log.Printf("Failed to decode YAML for %s/%s: %v", retriever.Bucket, retriever.Key, err)
-			return err
+			return nil, err
+		}
+	case Toml:
+		var tomlMap map[string]interface{}
+		if _, err := toml.NewDecoder(body).Decode(&tomlMap); err != nil {
+			log.Printf("Failed to decode TOML for %s/%s: %v", retriever.Bucket, retriever.Key, err)
+			return nil, err
+		}
+		parsed = ensureTomlValuesAreJson(tomlMap).(map[string]interface{})
+	default:
+		return nil, fmt.Errorf("unknown parser for %s/%s: %v", retriever.Bucket, retriever.Key, retriever.Parser)
+	}
+	return parsed, nil
+}
+
+// Whether any of the events are for the object or its version pointer
+func (retriever *S3ObjectRetriever) affectedBy(events []S3ObjectEvent) bool {
+	if hasObjectEvent(events, retriever.Bucket, retriever.Key) {
+		return true
+	}
+	return retriever.VersionPointer != "" && hasObjectEvent(events, retriever.Bucket, retriever.VersionPointer)
+}
+
+// Whether the error is s3 reporting that the object still matches the If-None-Match ETag
+func isNotModified(err error) bool {
+	var responseErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotModified
+}
+
+// Decodes every document in a yaml stream and merges them in order with the strategy. Empty documents are skipped
+func decodeYamlDocuments(body io.Reader, strategy MergeStrategy) (map[string]interface{}, error) {
+	decoder := yaml.NewDecoder(body)
+	var parsed map[string]interface{}
+	for i := 0; ; i++ {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			// An empty stream is still an error, like an empty json object
+			if errors.Is(err, io.EOF) && i > 0 {
+				break
+			}
+			return nil, err
 		}
-		yamlMap, err := ensureNodesAreFloat(&node)
+		if isEmptyYamlDocument(&node) {
+			continue
+		}
+		value, err := ensureNodesAreFloat(&node)
 		if err != nil {
-			log.Printf("Failed to convert decoded YAML to same types as decoded json for %s/%s: %v", retriever.Bucket, retriever.Key, err)
-			return err
+			return nil, fmt.Errorf("failed to convert decoded YAML document %d to same types as decoded json: %w", i, err)
 		}
-		retriever.data = &ConfigData{
-			json:           yamlMap.(map[string]interface{}),
-			lastModifiedAt: *output.LastModified,
+		document, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("YAML document %d is not a mapping", i)
 		}
-	default:
-		return fmt.Errorf("unknown parser for %s/%s: %v", retriever.Bucket, retriever.Key, err)
+		if parsed == nil {
+			parsed = document
+			continue
+		}
+		if err := mergeJson(&parsed, document, strategy); err != nil {
+			return nil, fmt.Errorf("failed to merge YAML document %d: %w", i, err)
+		}
+	}
+	if parsed == nil {
+		parsed = make(map[string]interface{})
+	}
+	return parsed, nil
+}
+
+// Whether a document has no content or only a null, like a document that is just a comment
+func isEmptyYamlDocument(node *yaml.Node) bool {
+	if node.Kind != yaml.DocumentNode {
+		return false
+	}
+	return len(node.Content) == 0 || (node.Content[0].Kind == yaml.ScalarNode && node.Content[0].Tag == "!!null")
+}
+
+// Whether the error is s3 reporting that the object does not match the If-Match ETag
+func isPreconditionFailed(err error) bool {
+	var responseErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusPreconditionFailed
+}
+
+func (retriever *S3ObjectRetriever) etagMismatch() error {
+	return fmt.Errorf("%s/%s is not at ETag %s: %w", retriever.Bucket, retriever.Key, retriever.ExpectedETag, ErrETagMismatch)
+}
+
+// Whether the error is s3 reporting that the object does not exist. A missing bucket is not counted
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return true
 	}
-	return nil
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound", "NoSuchVersion":
+			return true
+		case "NoSuchBucket":
+			return false
+		}
+	}
+	var responseErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound
 }
 
 // make yaml and json interfaces type compatible to ensure merging
@@ -195,4 +475,42 @@ func ensureNodesAreFloat(node *yaml.Node) (interface{}, error) {
 	default:
 		return nil, fmt.Errorf("unexpected yaml node kind to parse: %v", node.Kind)
 	}
-}
\ No newline at end of file
+}
+
+// Converts decoded toml to the same types as decoded json. Integers become float64, arrays of tables become
+// []interface{}, and dates and times become strings
+func ensureTomlValuesAreJson(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = ensureTomlValuesAreJson(val)
+		}
+		return v
+	case []map[string]interface{}:
+		s := make([]interface{}, len(v))
+		for i, el := range v {
+			s[i] = ensureTomlValuesAreJson(el)
+		}
+		return s
+	case []interface{}:
+		for i, el := range v {
+			v[i] = ensureTomlValuesAreJson(el)
+		}
+		return v
+	case int64:
+		return float64(v)
+	case time.Time:
+		// Values without an offset are decoded in placeholder locations
+		switch v.Location().String() {
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -17,6 +22,7 @@ import (
 const (
 	testBucket = "testbucket"
 	testKey = "testkey"
+	testETag = "\"9b2cf535f27731c974343645a3985328\""
 	testJson = `{
     "value1": {
 	  "arr": [
@@ -43,11 +49,19 @@ thing: true
 num:
 - 13
 - 12`
+	testToml = `
+thing = true
+num = [13, 12]
+
+[value1]
+arr = [{ inner = 1, value = 2 }, "stringValue"]
+`
 	testBadJson = `{ "unterminated": }`
 	testBadYaml = `service:
   another: 
     - value
 	missing`
+	testBadToml = `thing = `
 )
 
 var (
@@ -66,88 +80,41 @@ var (
 	}
 )
 
-func TestHasChangedOnInitial(t *testing.T) {
-	ctx := context.Background()
-	mockClient := newMockS3Client()
-	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
-		Bucket: testBucket,
-		Key: testKey,
-		Parser: Yaml,
-	})
-
-	changed, err := retriever.HasChanged(ctx)
-	require.Nil(t, err, "no has changed error")
-	assert.True(t, changed, "initial retriever returns hasChanged true")
-
-	// Ensure we didn't call the head object since we know we need to get some
-	mockClient.AssertNotCalled(t, "HeadObject")
-}
-
-func TestHasChangedAPIError(t *testing.T) {
-	now := time.Now()
-	ctx := context.Background()
-	mockClient := newMockS3Client()
-	var emptyThird []func(*s3.Options) = nil
-	mockClient.On("HeadObject", ctx, mock.MatchedBy(func(arg1 interface{}) bool {
-		input, ok := arg1.(*s3.HeadObjectInput)
-		if !ok {
-			return false
-		}
-		return *input.Bucket == testBucket && *input.Key == testKey
-	}), emptyThird).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, errors.New("Oh no!"))
-	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
-		Bucket: testBucket,
-		Key: testKey,
-		Parser: Yaml,
-	})
-
-	retriever.data = &ConfigData{
-		json: make(map[string]interface{}),
-		lastModifiedAt: now,
-	}
-
-	changed, err := retriever.HasChanged(ctx)
-	require.ErrorContains(t, err, "Oh no!")
-	assert.False(t, changed, "has changed is false on error")
-}
-
-func TestHasChangedIfRetrieveOlder(t *testing.T) {
-	now := time.Now()
+func TestRetrieveNotModified(t *testing.T) {
 	ctx := context.Background()
 	mockClient := newMockS3Client()
 	var emptyThird []func(*s3.Options) = nil
-	mockClient.On("HeadObject", ctx, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, nil)
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(nil, newNotModifiedError())
 	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
 		Bucket: testBucket,
 		Key: testKey,
 		Parser: Yaml,
 	})
 
-	// Simulate older data
-	retriever.data = &ConfigData{
+	previous := &ConfigData{
 		json: make(map[string]interface{}),
-		lastModifiedAt: now.Add(time.Duration(-5) * time.Second),
+		etag: testETag,
 	}
+	retriever.data = previous
 
-	changed, err := retriever.HasChanged(ctx)
-	assert.Nil(t, err)
-	require.True(t, changed, "has changed is false on error")
-	mockClient.AssertCalled(t, "HeadObject", ctx, mock.MatchedBy(func(arg1 *s3.HeadObjectInput) bool {
-		return *arg1.Bucket == testBucket && *arg1.Key == testKey
+	changed, err := retriever.Retrieve(ctx)
+	require.Nil(t, err)
+	assert.False(t, changed, "not modified is unchanged")
+	assert.Same(t, previous, retriever.data)
+	mockClient.AssertCalled(t, "GetObject", ctx, mock.MatchedBy(func(arg1 *s3.GetObjectInput) bool {
+		return *arg1.Bucket == testBucket && *arg1.Key == testKey && *arg1.IfNoneMatch == testETag
 	}), emptyThird)
 }
 
-func TestHasChangedIfRetrieveSame(t *testing.T) {
+func TestRetrieveSameETag(t *testing.T) {
 	now := time.Now()
 	ctx := context.Background()
 	mockClient := newMockS3Client()
-	var emptyThird []func(*s3.Options) = nil
-	mockClient.On("HeadObject", ctx, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+	// Simulate an s3 compatible store that ignores If-None-Match
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 		LastModified: &now,
+		ETag: aws.String(testETag),
+		Body: io.NopCloser(bytes.NewReader([]byte(testYaml))),
 	}, nil)
 	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
 		Bucket: testBucket,
@@ -155,44 +122,38 @@ func TestHasChangedIfRetrieveSame(t *testing.T) {
 		Parser: Yaml,
 	})
 
-	retriever.data = &ConfigData{
+	previous := &ConfigData{
 		json: make(map[string]interface{}),
-		lastModifiedAt: now,
+		etag: testETag,
 	}
+	retriever.data = previous
 
-	changed, err := retriever.HasChanged(ctx)
+	changed, err := retriever.Retrieve(ctx)
 	require.Nil(t, err)
-	assert.False(t, changed, "has changed is false on equal")
-	mockClient.AssertCalled(t, "HeadObject", ctx, mock.MatchedBy(func(arg1 *s3.HeadObjectInput) bool {
-		return *arg1.Bucket == testBucket && *arg1.Key == testKey
-	}), emptyThird)
+	assert.False(t, changed, "same etag is unchanged")
+	assert.Same(t, previous, retriever.data)
 }
 
-func TestHasChangedIfRetrieveNewer(t *testing.T) {
-	now := time.Now()
+func TestRetrieveAPIError(t *testing.T) {
 	ctx := context.Background()
 	mockClient := newMockS3Client()
-	var emptyThird []func(*s3.Options) = nil
-	mockClient.On("HeadObject", ctx, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
-		LastModified: &now,
-	}, nil)
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(nil, errors.New("Oh no!"))
 	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
 		Bucket: testBucket,
 		Key: testKey,
 		Parser: Yaml,
 	})
 
-	retriever.data = &ConfigData{
+	previous := &ConfigData{
 		json: make(map[string]interface{}),
-		lastModifiedAt: now.Add(time.Duration(5) * time.Second),
+		etag: testETag,
 	}
+	retriever.data = previous
 
-	changed, err := retriever.HasChanged(ctx)
-	require.Nil(t, err)
-	assert.False(t, changed, "has changed is false on equal")
-	mockClient.AssertCalled(t, "HeadObject", ctx, mock.MatchedBy(func(arg1 *s3.HeadObjectInput) bool {
-		return *arg1.Bucket == testBucket && *arg1.Key == testKey
-	}), emptyThird)
+	changed, err := retriever.Retrieve(ctx)
+	require.ErrorContains(t, err, "Oh no!")
+	assert.False(t, changed, "changed is false on error")
+	assert.Same(t, previous, retriever.data)
 }
 
 func TestRetrieveInitial(t *testing.T) {
@@ -202,6 +163,7 @@ func TestRetrieveInitial(t *testing.T) {
 	} {
 		{"yaml", Yaml},
 		{"json", Json},
+		{"toml", Toml},
 	}
 
 	for _, tt := range tests {
@@ -217,12 +179,15 @@ func TestRetrieveInitial(t *testing.T) {
 				raw = testYaml
 			case Json:
 				raw = testJson
+			case Toml:
+				raw = testToml
 			default:
 				t.Errorf("Unexpected parser for test %v", tt.parser)
 				return
 			}
 			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 				LastModified: &now,
+				ETag: aws.String(testETag),
 				Body: io.NopCloser(bytes.NewReader([]byte(raw))),
 			}, nil)
 			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
@@ -231,14 +196,15 @@ func TestRetrieveInitial(t *testing.T) {
 				Parser: tt.parser,
 			})
 		
-			err := retriever.Retrieve(ctx)
+			changed, err := retriever.Retrieve(ctx)
 			require.Nil(t, err)
+			assert.True(t, changed)
 			assert.Equal(t, &ConfigData{
 				json: testJsonMap,
-				lastModifiedAt: now,
+				etag: testETag,
 			}, retriever.data)
 			mockClient.AssertCalled(t, "GetObject", ctx, mock.MatchedBy(func(arg1 *s3.GetObjectInput) bool {
-				return *arg1.Bucket == testBucket && *arg1.Key == testKey
+				return *arg1.Bucket == testBucket && *arg1.Key == testKey && arg1.IfNoneMatch == nil
 			}), emptyThird)
 		})
 	}
@@ -251,6 +217,7 @@ func TestRetrieveOverwrite(t *testing.T) {
 	} {
 		{"yaml", Yaml},
 		{"json", Json},
+		{"toml", Toml},
 	}
 
 	for _, tt := range tests {
@@ -266,12 +233,15 @@ func TestRetrieveOverwrite(t *testing.T) {
 				raw = testYaml
 			case Json:
 				raw = testJson
+			case Toml:
+				raw = testToml
 			default:
 				t.Errorf("Unexpected parser for test %v", tt.parser)
 				return
 			}
 			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 				LastModified: &now,
+				ETag: aws.String(testETag),
 				Body: io.NopCloser(bytes.NewReader([]byte(raw))),
 			}, nil)
 			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
@@ -285,16 +255,18 @@ func TestRetrieveOverwrite(t *testing.T) {
 					"someValue": float64(22),
 					"another": "string",
 				},
+				etag: "\"previous\"",
 			}
 		
-			err := retriever.Retrieve(ctx)
+			changed, err := retriever.Retrieve(ctx)
 			require.Nil(t, err)
+			assert.True(t, changed)
 			assert.Equal(t, &ConfigData{
 				json: testJsonMap,
-				lastModifiedAt: now,
+				etag: testETag,
 			}, retriever.data)
 			mockClient.AssertCalled(t, "GetObject", ctx, mock.MatchedBy(func(arg1 *s3.GetObjectInput) bool {
-				return *arg1.Bucket == testBucket && *arg1.Key == testKey
+				return *arg1.Bucket == testBucket && *arg1.Key == testKey && *arg1.IfNoneMatch == "\"previous\""
 			}), emptyThird)
 		})
 	}
@@ -307,6 +279,7 @@ func TestRetrieveErrors(t *testing.T) {
 	} {
 		{"yaml", Yaml},
 		{"json", Json},
+		{"toml", Toml},
 	}
 
 	for _, tt := range tests {
@@ -324,12 +297,16 @@ func TestRetrieveErrors(t *testing.T) {
 			case Json:
 				raw = testBadJson
 				expectedErrorMatch = "invalid character '}'"
+			case Toml:
+				raw = testBadToml
+				expectedErrorMatch = "unexpected EOF; expected value"
 			default:
 				t.Errorf("Unexpected parser for test %v", tt.parser)
 				return
 			}
 			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 				LastModified: &now,
+				ETag: aws.String(testETag),
 				Body: io.NopCloser(bytes.NewReader([]byte(raw))),
 			}, nil)
 			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
@@ -340,12 +317,254 @@ func TestRetrieveErrors(t *testing.T) {
 		
 		     
 
-			err := retriever.Retrieve(ctx)
+			changed, err := retriever.Retrieve(ctx)
 			require.ErrorContains(t, err, expectedErrorMatch)
+			assert.False(t, changed)
 			assert.Nil(t, retriever.data)
 			mockClient.AssertCalled(t, "GetObject", ctx, mock.MatchedBy(func(arg1 *s3.GetObjectInput) bool {
 				return *arg1.Bucket == testBucket && *arg1.Key == testKey
 			}), emptyThird)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestParseParser(t *testing.T) {
+	parser, err := ParseParser(" TOML ")
+	require.NoError(t, err)
+	assert.Equal(t, Toml, parser)
+
+	parser, err = InferParser("dynamic/legacy.toml")
+	require.NoError(t, err)
+	assert.Equal(t, Toml, parser)
+}
+
+func TestRetrieveTomlTypes(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String(testETag),
+		Body: io.NopCloser(bytes.NewReader([]byte(`
+[[tls.certificates]]
+certFile = "a.cert"
+keyFile = "a.key"
+
+[[tls.certificates]]
+certFile = "b.cert"
+keyFile = "b.key"
+
+[dates]
+offset = 2024-01-02T03:04:05Z
+local = 2024-01-02
+localTime = 07:32:00
+localDateTime = 1979-05-27T07:32:00
+ratio = 0.5
+`))),
+	}, nil)
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket: testBucket,
+		Key: testKey,
+		Parser: Toml,
+	})
+
+	changed, err := retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]interface{} {
+		"tls": map[string]interface{} {
+			"certificates": []interface{} {
+				map[string]interface{} {"certFile": "a.cert", "keyFile": "a.key"},
+				map[string]interface{} {"certFile": "b.cert", "keyFile": "b.key"},
+			},
+		},
+		"dates": map[string]interface{} {
+			"offset": "2024-01-02T03:04:05Z",
+			"local": "2024-01-02",
+			"localTime": "07:32:00",
+			"localDateTime": "1979-05-27T07:32:00",
+			"ratio": 0.5,
+		},
+	}, retriever.data.json)
+}
+
+func TestRetrieveYamlDocuments(t *testing.T) {
+	var tests = []struct {
+		name     string
+		raw      string
+		strategy MergeStrategy
+		expected map[string]interface{}
+	}{
+		{
+			"single document",
+			testYaml,
+			"",
+			testJsonMap,
+		},
+		{
+			"documents are merged in order",
+			`
+http:
+  routers:
+    api:
+      rule: Host(` + "`a.com`" + `)
+      middlewares: [auth]
+---
+http:
+  services:
+    api:
+      loadBalancer: {}
+---
+http:
+  routers:
+    api:
+      rule: Host(` + "`b.com`" + `)
+      middlewares: [headers]
+`,
+			"",
+			map[string]interface{}{
+				"http": map[string]interface{}{
+					"routers": map[string]interface{}{
+						"api": map[string]interface{}{
+							"rule":        "Host(`a.com`)",
+							"middlewares": []interface{}{"auth", "headers"},
+						},
+					},
+					"services": map[string]interface{}{
+						"api": map[string]interface{}{"loadBalancer": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+		{
+			"merge strategy",
+			"value: 1\nlist: [a]\n---\nvalue: 2\nlist: [b]\n",
+			MergeReplace,
+			map[string]interface{}{"value": float64(2), "list": []interface{}{"b"}},
+		},
+		{
+			"empty documents are skipped",
+			"---\nvalue: 1\n---\n# nothing here\n---\nother: true\n",
+			"",
+			map[string]interface{}{"value": float64(1), "other": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := newMockS3Client()
+			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+				ETag: aws.String(testETag),
+				Body: io.NopCloser(bytes.NewReader([]byte(tt.raw))),
+			}, nil)
+			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+				Bucket: testBucket,
+				Key: testKey,
+				Parser: Yaml,
+				ObjectOptions: ObjectOptions{MergeStrategy: tt.strategy},
+			})
+
+			changed, err := retriever.Retrieve(ctx)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, tt.expected, retriever.data.json)
+		})
+	}
+}
+
+func TestRetrieveYamlDocumentErrors(t *testing.T) {
+	var tests = []struct {
+		name        string
+		raw         string
+		expectedErr string
+	}{
+		{"not a mapping", "value: 1\n---\n- a\n- b\n", "YAML document 1 is not a mapping"},
+		{"bad later document", "value: 1\n---\n" + testBadYaml, "yaml: line"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := newMockS3Client()
+			mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+				ETag: aws.String(testETag),
+				Body: io.NopCloser(bytes.NewReader([]byte(tt.raw))),
+			}, nil)
+			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+				Bucket: testBucket,
+				Key: testKey,
+				Parser: Yaml,
+			})
+
+			changed, err := retriever.Retrieve(ctx)
+			assert.ErrorContains(t, err, tt.expectedErr)
+			assert.False(t, changed)
+			assert.Nil(t, retriever.data)
+		})
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"no such key", &types.NoSuchKey{}, true},
+		{"not found", &types.NotFound{}, true},
+		{"generic no such key code", &smithy.GenericAPIError{Code: "NoSuchKey"}, true},
+		{"no such bucket", &smithy.GenericAPIError{Code: "NoSuchBucket"}, false},
+		{"404 status", &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusNotFound}},
+			Err:      errors.New("not found"),
+		}, true},
+		{"not modified", newNotModifiedError(), false},
+		{"other", errors.New("Oh no!"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isNotFound(tt.err))
+		})
+	}
+}
+
+func TestRetrieveRequiredNotFound(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(nil, &types.NoSuchKey{})
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket: testBucket,
+		Key: testKey,
+		Parser: Yaml,
+	})
+	previous := &ConfigData{json: testJsonMap, etag: testETag}
+	retriever.data = previous
+
+	changed, err := retriever.Retrieve(ctx)
+	assert.ErrorContains(t, err, "required object testbucket/testkey does not exist")
+	assert.False(t, changed)
+	assert.Same(t, previous, retriever.data, "the previous data is kept")
+}
+
+func TestRetrieveOptionalNotFound(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(nil, &types.NoSuchKey{})
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket: testBucket,
+		Key: testKey,
+		Parser: Yaml,
+		Optional: true,
+	})
+	retriever.data = &ConfigData{json: testJsonMap, etag: testETag}
+
+	changed, err := retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed, "dropping the data is a change")
+	assert.Nil(t, retriever.data)
+
+	changed, err = retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed, "still missing is unchanged")
+	assert.Nil(t, retriever.data)
+}
@@ -0,0 +1,622 @@
+package s3provider
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+type schemaKind uint8
+
+const (
+	// Any value is allowed
+	schemaAny schemaKind = iota
+	schemaString
+	schemaInt
+	schemaBool
+	// A Golang duration string or a number of seconds
+	schemaDuration
+	// An object with a fixed set of fields
+	schemaObject
+	// An object with arbitrary names that all have the same kind of value
+	schemaMap
+	schemaList
+)
+
+// Describes the allowed shape of a value in the dynamic configuration
+type schema struct {
+	kind schemaKind
+	// The allowed fields of an object
+	fields map[string]*schema
+	// The value of every entry of a map or list
+	elem *schema
+}
+
+var (
+	anyValue      = &schema{kind: schemaAny}
+	stringValue   = &schema{kind: schemaString}
+	intValue      = &schema{kind: schemaInt}
+	boolValue     = &schema{kind: schemaBool}
+	durationValue = &schema{kind: schemaDuration}
+	stringList    = listOf(stringValue)
+	stringMap     = mapOf(stringValue)
+)
+
+func object(fields map[string]*schema) *schema {
+	return &schema{kind: schemaObject, fields: fields}
+}
+
+func mapOf(elem *schema) *schema {
+	return &schema{kind: schemaMap, elem: elem}
+}
+
+func listOf(elem *schema) *schema {
+	return &schema{kind: schemaList, elem: elem}
+}
+
+var (
+	certificateSchema = object(map[string]*schema{
+		"certFile": stringValue,
+		"keyFile":  stringValue,
+	})
+	domainsSchema = listOf(object(map[string]*schema{
+		"main": stringValue,
+		"sans": stringList,
+	}))
+	spiffeSchema = object(map[string]*schema{
+		"ids":         stringList,
+		"trustDomain": stringValue,
+	})
+	weightedServicesSchema = listOf(object(map[string]*schema{
+		"name":   stringValue,
+		"weight": intValue,
+	}))
+	ipStrategySchema = object(map[string]*schema{
+		"depth":       intValue,
+		"excludedIPs": stringList,
+		"ipv6Subnet":  intValue,
+	})
+	sourceCriterionSchema = object(map[string]*schema{
+		"ipStrategy":        ipStrategySchema,
+		"requestHeaderName": stringValue,
+		"requestHost":       boolValue,
+	})
+	stickySchema = object(map[string]*schema{
+		"cookie": object(map[string]*schema{
+			"name":     stringValue,
+			"secure":   boolValue,
+			"httpOnly": boolValue,
+			"sameSite": stringValue,
+			"maxAge":   intValue,
+			"path":     stringValue,
+			"domain":   stringValue,
+		}),
+	})
+	healthCheckSchema = object(map[string]*schema{
+		"scheme":            stringValue,
+		"mode":              stringValue,
+		"path":              stringValue,
+		"method":            stringValue,
+		"status":            intValue,
+		"port":              intValue,
+		"interval":          durationValue,
+		"unhealthyInterval": durationValue,
+		"timeout":           durationValue,
+		"hostname":          stringValue,
+		"followRedirects":   boolValue,
+		"headers":           stringMap,
+	})
+	authSchema = object(map[string]*schema{
+		"users":        stringList,
+		"usersFile":    stringValue,
+		"realm":        stringValue,
+		"removeHeader": boolValue,
+		"headerField":  stringValue,
+	})
+	ipAllowListSchema = object(map[string]*schema{
+		"sourceRange":      stringList,
+		"ipStrategy":       ipStrategySchema,
+		"rejectStatusCode": intValue,
+	})
+)
+
+var httpMiddlewareSchema = object(map[string]*schema{
+	"addPrefix": object(map[string]*schema{
+		"prefix": stringValue,
+	}),
+	"basicAuth": authSchema,
+	"buffering": object(map[string]*schema{
+		"maxRequestBodyBytes":  intValue,
+		"memRequestBodyBytes":  intValue,
+		"maxResponseBodyBytes": intValue,
+		"memResponseBodyBytes": intValue,
+		"retryExpression":      stringValue,
+	}),
+	"chain": object(map[string]*schema{
+		"middlewares": stringList,
+	}),
+	"circuitBreaker": object(map[string]*schema{
+		"expression":       stringValue,
+		"checkPeriod":      durationValue,
+		"fallbackDuration": durationValue,
+		"recoveryDuration": durationValue,
+		"responseCode":     intValue,
+	}),
+	"compress": object(map[string]*schema{
+		"excludedContentTypes": stringList,
+		"includedContentTypes": stringList,
+		"minResponseBodyBytes": intValue,
+		"defaultEncoding":      stringValue,
+		"encodings":            stringList,
+	}),
+	"contentType": object(map[string]*schema{
+		"autoDetect": boolValue,
+	}),
+	"digestAuth": authSchema,
+	"errors": object(map[string]*schema{
+		"status":         stringList,
+		"service":        stringValue,
+		"query":          stringValue,
+		"statusRewrites": mapOf(intValue),
+	}),
+	"forwardAuth": object(map[string]*schema{
+		"address": stringValue,
+		"tls": object(map[string]*schema{
+			"ca":                 stringValue,
+			"cert":               stringValue,
+			"key":                stringValue,
+			"insecureSkipVerify": boolValue,
+			"caOptional":         boolValue,
+		}),
+		"trustForwardHeader":       boolValue,
+		"authResponseHeaders":      stringList,
+		"authResponseHeadersRegex": stringValue,
+		"authRequestHeaders":       stringList,
+		"addAuthCookiesToResponse": stringList,
+		"headerField":              stringValue,
+		"forwardBody":              boolValue,
+		"maxBodySize":              intValue,
+		"preserveLocationHeader":   boolValue,
+		"preserveRequestMethod":    boolValue,
+	}),
+	"grpcWeb": object(map[string]*schema{
+		"allowOrigins": stringList,
+	}),
+	"headers": object(map[string]*schema{
+		"customRequestHeaders":              stringMap,
+		"customResponseHeaders":             stringMap,
+		"accessControlAllowCredentials":     boolValue,
+		"accessControlAllowHeaders":         stringList,
+		"accessControlAllowMethods":         stringList,
+		"accessControlAllowOriginList":      stringList,
+		"accessControlAllowOriginListRegex": stringList,
+		"accessControlExposeHeaders":        stringList,
+		"accessControlMaxAge":               intValue,
+		"addVaryHeader":                     boolValue,
+		"allowedHosts":                      stringList,
+		"hostsProxyHeaders":                 stringList,
+		"sslProxyHeaders":                   stringMap,
+		"stsSeconds":                        intValue,
+		"stsIncludeSubdomains":              boolValue,
+		"stsPreload":                        boolValue,
+		"forceSTSHeader":                    boolValue,
+		"frameDeny":                         boolValue,
+		"customFrameOptionsValue":           stringValue,
+		"contentTypeNosniff":                boolValue,
+		"browserXssFilter":                  boolValue,
+		"customBrowserXSSValue":             stringValue,
+		"contentSecurityPolicy":             stringValue,
+		"contentSecurityPolicyReportOnly":   stringValue,
+		"publicKey":                         stringValue,
+		"referrerPolicy":                    stringValue,
+		"permissionsPolicy":                 stringValue,
+		"featurePolicy":                     stringValue,
+		"isDevelopment":                     boolValue,
+		"sslRedirect":                       boolValue,
+		"sslTemporaryRedirect":              boolValue,
+		"sslHost":                           stringValue,
+		"sslForceHost":                      boolValue,
+	}),
+	"ipAllowList": ipAllowListSchema,
+	"ipWhiteList": ipAllowListSchema,
+	"inFlightReq": object(map[string]*schema{
+		"amount":          intValue,
+		"sourceCriterion": sourceCriterionSchema,
+	}),
+	"passTLSClientCert": object(map[string]*schema{
+		"pem":  boolValue,
+		"info": anyValue,
+	}),
+	// Plugin settings are defined by each plugin
+	"plugin": mapOf(anyValue),
+	"rateLimit": object(map[string]*schema{
+		"average":         intValue,
+		"period":          durationValue,
+		"burst":           intValue,
+		"sourceCriterion": sourceCriterionSchema,
+		"redis":           anyValue,
+	}),
+	"redirectRegex": object(map[string]*schema{
+		"regex":       stringValue,
+		"replacement": stringValue,
+		"permanent":   boolValue,
+	}),
+	"redirectScheme": object(map[string]*schema{
+		"scheme":    stringValue,
+		"port":      stringValue,
+		"permanent": boolValue,
+	}),
+	"replacePath": object(map[string]*schema{
+		"path": stringValue,
+	}),
+	"replacePathRegex": object(map[string]*schema{
+		"regex":       stringValue,
+		"replacement": stringValue,
+	}),
+	"retry": object(map[string]*schema{
+		"attempts":        intValue,
+		"initialInterval": durationValue,
+	}),
+	"stripPrefix": object(map[string]*schema{
+		"prefixes":   stringList,
+		"forceSlash": boolValue,
+	}),
+	"stripPrefixRegex": object(map[string]*schema{
+		"regex": stringList,
+	}),
+})
+
+var httpSchema = object(map[string]*schema{
+	"routers": mapOf(object(map[string]*schema{
+		"entryPoints": stringList,
+		"middlewares": stringList,
+		"service":     stringValue,
+		"rule":        stringValue,
+		"ruleSyntax":  stringValue,
+		"priority":    intValue,
+		"tls": object(map[string]*schema{
+			"options":      stringValue,
+			"certResolver": stringValue,
+			"domains":      domainsSchema,
+		}),
+		"observability": object(map[string]*schema{
+			"accessLogs": boolValue,
+			"tracing":    boolValue,
+			"metrics":    boolValue,
+		}),
+	})),
+	"services": mapOf(object(map[string]*schema{
+		"loadBalancer": object(map[string]*schema{
+			"servers": listOf(object(map[string]*schema{
+				"url":          stringValue,
+				"weight":       intValue,
+				"preservePath": boolValue,
+			})),
+			"sticky":         stickySchema,
+			"healthCheck":    healthCheckSchema,
+			"passHostHeader": boolValue,
+			"responseForwarding": object(map[string]*schema{
+				"flushInterval": durationValue,
+			}),
+			"serversTransport": stringValue,
+		}),
+		"weighted": object(map[string]*schema{
+			"services":    weightedServicesSchema,
+			"sticky":      stickySchema,
+			"healthCheck": object(map[string]*schema{}),
+		}),
+		"mirroring": object(map[string]*schema{
+			"service":     stringValue,
+			"mirrorBody":  boolValue,
+			"maxBodySize": intValue,
+			"mirrors": listOf(object(map[string]*schema{
+				"name":    stringValue,
+				"percent": intValue,
+			})),
+			"healthCheck": object(map[string]*schema{}),
+		}),
+		"failover": object(map[string]*schema{
+			"service":     stringValue,
+			"fallback":    stringValue,
+			"healthCheck": object(map[string]*schema{}),
+		}),
+	})),
+	"middlewares": mapOf(httpMiddlewareSchema),
+	"serversTransports": mapOf(object(map[string]*schema{
+		"serverName":          stringValue,
+		"insecureSkipVerify":  boolValue,
+		"rootCAs":             stringList,
+		"certificates":        listOf(certificateSchema),
+		"maxIdleConnsPerHost": intValue,
+		"forwardingTimeouts": object(map[string]*schema{
+			"dialTimeout":           durationValue,
+			"responseHeaderTimeout": durationValue,
+			"idleConnTimeout":       durationValue,
+			"readIdleTimeout":       durationValue,
+			"pingTimeout":           durationValue,
+		}),
+		"disableHTTP2": boolValue,
+		"peerCertURI":  stringValue,
+		"spiffe":       spiffeSchema,
+	})),
+})
+
+var tcpSchema = object(map[string]*schema{
+	"routers": mapOf(object(map[string]*schema{
+		"entryPoints": stringList,
+		"middlewares": stringList,
+		"service":     stringValue,
+		"rule":        stringValue,
+		"ruleSyntax":  stringValue,
+		"priority":    intValue,
+		"tls": object(map[string]*schema{
+			"passthrough":  boolValue,
+			"options":      stringValue,
+			"certResolver": stringValue,
+			"domains":      domainsSchema,
+		}),
+	})),
+	"services": mapOf(object(map[string]*schema{
+		"loadBalancer": object(map[string]*schema{
+			"servers": listOf(object(map[string]*schema{
+				"address": stringValue,
+				"tls":     boolValue,
+				"weight":  intValue,
+			})),
+			"serversTransport": stringValue,
+			"proxyProtocol": object(map[string]*schema{
+				"version": intValue,
+			}),
+			"terminationDelay": intValue,
+		}),
+		"weighted": object(map[string]*schema{
+			"services": weightedServicesSchema,
+		}),
+	})),
+	"middlewares": mapOf(object(map[string]*schema{
+		"ipAllowList": object(map[string]*schema{
+			"sourceRange": stringList,
+		}),
+		"ipWhiteList": object(map[string]*schema{
+			"sourceRange": stringList,
+		}),
+		"inFlightConn": object(map[string]*schema{
+			"amount": intValue,
+		}),
+	})),
+	"serversTransports": mapOf(object(map[string]*schema{
+		"dialKeepAlive":    durationValue,
+		"dialTimeout":      durationValue,
+		"terminationDelay": durationValue,
+		"proxyProtocol": object(map[string]*schema{
+			"version": intValue,
+		}),
+		"tls": object(map[string]*schema{
+			"serverName":         stringValue,
+			"insecureSkipVerify": boolValue,
+			"rootCAs":            stringList,
+			"certificates":       listOf(certificateSchema),
+			"peerCertURI":        stringValue,
+			"spiffe":             spiffeSchema,
+		}),
+	})),
+})
+
+var udpSchema = object(map[string]*schema{
+	"routers": mapOf(object(map[string]*schema{
+		"entryPoints": stringList,
+		"service":     stringValue,
+	})),
+	"services": mapOf(object(map[string]*schema{
+		"loadBalancer": object(map[string]*schema{
+			"servers": listOf(object(map[string]*schema{
+				"address": stringValue,
+			})),
+		}),
+		"weighted": object(map[string]*schema{
+			"services": weightedServicesSchema,
+		}),
+	})),
+})
+
+var tlsSchema = object(map[string]*schema{
+	"certificates": listOf(object(map[string]*schema{
+		"certFile": stringValue,
+		"keyFile":  stringValue,
+		"stores":   stringList,
+	})),
+	"options": mapOf(object(map[string]*schema{
+		"minVersion":       stringValue,
+		"maxVersion":       stringValue,
+		"cipherSuites":     stringList,
+		"curvePreferences": stringList,
+		"clientAuth": object(map[string]*schema{
+			"caFiles":        stringList,
+			"clientAuthType": stringValue,
+		}),
+		"sniStrict":                boolValue,
+		"alpnProtocols":            stringList,
+		"preferServerCipherSuites": boolValue,
+		"disableSessionTickets":    boolValue,
+	})),
+	"stores": mapOf(object(map[string]*schema{
+		"defaultCertificate": certificateSchema,
+		"defaultGeneratedCert": object(map[string]*schema{
+			"resolver": stringValue,
+			"domain": object(map[string]*schema{
+				"main": stringValue,
+				"sans": stringList,
+			}),
+		}),
+	})),
+})
+
+// The known structure of traefik's dynamic configuration, written by hand from pkg/config/dynamic in traefik v3.4.0.
+// Run `make check_schema TRAEFIK_VERSION=<version>` to compare it with traefik's types
+var dynamicConfigSchema = object(map[string]*schema{
+	"http": httpSchema,
+	"tcp":  tcpSchema,
+	"udp":  udpSchema,
+	"tls":  tlsSchema,
+})
+
+// A value that does not match the schema
+type schemaError struct {
+	// The dotted path to the value, i.e. http.services.api.loadBalancer.servers[0].url
+	path    string
+	message string
+}
+
+func (e schemaError) String() string {
+	return e.path + ": " + e.message
+}
+
+// Checks the merged configuration against the known structure of traefik's dynamic configuration
+// and returns an error naming the path of every value that does not match
+func validateDynamicConfig(data map[string]interface{}) error {
+	var errs []schemaError
+	dynamicConfigSchema.validate("", data, &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.String()
+	}
+	return fmt.Errorf("invalid dynamic configuration: %s", strings.Join(messages, "; "))
+}
+
+func (s *schema) validate(path string, value interface{}, errs *[]schemaError) {
+	// An empty value is the same as leaving it out
+	if value == nil {
+		return
+	}
+
+	switch s.kind {
+	case schemaString, schemaBool, schemaInt, schemaDuration:
+		if expected := s.scalarMismatch(value); expected != "" {
+			*errs = append(*errs, typeError(path, expected, value))
+		}
+	case schemaList:
+		s.validateList(path, value, errs)
+	case schemaMap:
+		s.validateMap(path, value, errs)
+	case schemaObject:
+		s.validateObject(path, value, errs)
+	}
+}
+
+// Returns what a scalar value was expected to be if it is not of the schema's kind, or "" if it is
+func (s *schema) scalarMismatch(value interface{}) string {
+	switch s.kind {
+	case schemaString:
+		if _, ok := value.(string); !ok {
+			return "a string"
+		}
+	case schemaBool:
+		if _, ok := value.(bool); !ok {
+			return "a boolean"
+		}
+	case schemaInt:
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return "an integer"
+		}
+	case schemaDuration:
+		switch value.(type) {
+		case string, float64:
+		default:
+			return "a duration"
+		}
+	}
+	return ""
+}
+
+func (s *schema) validateList(path string, value interface{}, errs *[]schemaError) {
+	list, ok := value.([]interface{})
+	if !ok {
+		*errs = append(*errs, typeError(path, "a list", value))
+		return
+	}
+	for i, el := range list {
+		s.elem.validate(fmt.Sprintf("%s[%d]", path, i), el, errs)
+	}
+}
+
+func (s *schema) validateMap(path string, value interface{}, errs *[]schemaError) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		*errs = append(*errs, typeError(path, "an object", value))
+		return
+	}
+	for _, key := range sortedKeys(m) {
+		s.elem.validate(joinPath(path, key), m[key], errs)
+	}
+}
+
+func (s *schema) validateObject(path string, value interface{}, errs *[]schemaError) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		*errs = append(*errs, typeError(path, "an object", value))
+		return
+	}
+	for _, key := range sortedKeys(m) {
+		field := s.field(key)
+		if field == nil {
+			*errs = append(*errs, schemaError{path: joinPath(path, key), message: "unknown field"})
+			continue
+		}
+		field.validate(joinPath(path, key), m[key], errs)
+	}
+}
+
+// Returns the schema of an object's field. Like traefik, field names are matched case insensitively
+func (s *schema) field(name string) *schema {
+	if field, ok := s.fields[name]; ok {
+		return field
+	}
+	for fieldName, field := range s.fields {
+		if strings.EqualFold(fieldName, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+func typeError(path string, expected string, value interface{}) schemaError {
+	return schemaError{path: path, message: fmt.Sprintf("expected %s but got %s", expected, jsonTypeName(value))}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,290 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const validDynamicConfig = `
+http:
+  routers:
+    api:
+      rule: Host(` + "`api.example.com`" + `)
+      entryPoints: [websecure]
+      middlewares: [auth, strip]
+      service: api
+      priority: 10
+      tls:
+        certResolver: letsencrypt
+        domains:
+          - main: example.com
+            sans: [api.example.com]
+  services:
+    api:
+      loadBalancer:
+        servers:
+          - url: http://10.0.0.1:8080
+            weight: 2
+        passHostHeader: true
+        healthCheck:
+          path: /health
+          interval: 10s
+          timeout: 3
+        responseForwarding:
+          flushInterval: 100ms
+    split:
+      weighted:
+        services:
+          - name: api
+            weight: 3
+  middlewares:
+    auth:
+      basicAuth:
+        users: ["test:$apr1$H6uskkkW$IgXLP6ewTrSuBkTrqE8wj/"]
+    strip:
+      stripPrefix:
+        prefixes: [/api]
+    custom:
+      plugin:
+        someplugin:
+          anything: [1, two]
+  serversTransports:
+    internal:
+      insecureSkipVerify: true
+      ForwardingTimeouts:
+        dialTimeout: 5s
+tcp:
+  routers:
+    db:
+      rule: HostSNI(` + "`*`" + `)
+      service: db
+      tls:
+        passthrough: true
+  services:
+    db:
+      loadBalancer:
+        servers:
+          - address: 10.0.0.2:5432
+udp:
+  services:
+    dns:
+      loadBalancer:
+        servers:
+          - address: 10.0.0.3:53
+tls:
+  certificates:
+    - certFile: /certs/a.cert
+      keyFile: /certs/a.key
+      stores: [default]
+  options:
+    modern:
+      minVersion: VersionTLS13
+`
+
+func mustUnmarshalYaml(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var node yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(raw), &node))
+	m, err := ensureNodesAreFloat(&node)
+	require.NoError(t, err)
+	return m.(map[string]interface{})
+}
+
+func TestValidateDynamicConfigValid(t *testing.T) {
+	assert.NoError(t, validateDynamicConfig(mustUnmarshalYaml(t, validDynamicConfig)))
+	assert.NoError(t, validateDynamicConfig(map[string]interface{}{}))
+}
+
+func TestValidateDynamicConfigErrors(t *testing.T) {
+	var tests = []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{"unknown top level key", `{"routres": {}}`, "routres: unknown field"},
+		{"unknown section", `{"http": {"router": {}}}`, "http.router: unknown field"},
+		{"unknown router field", `{"http": {"routers": {"api": {"rul": "Path(` + "`/`" + `)"}}}}`, "http.routers.api.rul: unknown field"},
+		{"router rule type", `{"http": {"routers": {"api": {"rule": 5}}}}`, "http.routers.api.rule: expected a string but got a number"},
+		{"router priority", `{"http": {"routers": {"api": {"priority": 1.5}}}}`, "http.routers.api.priority: expected an integer but got a number"},
+		{"middlewares list", `{"http": {"routers": {"api": {"middlewares": "auth"}}}}`, "http.routers.api.middlewares: expected a list but got a string"},
+		{"server url", `{"http": {"services": {"api": {"loadBalancer": {"servers": [{"url": "http://a"}, {"url": true}]}}}}}`, "http.services.api.loadBalancer.servers[1].url: expected a string but got a boolean"},
+		{"unknown middleware", `{"http": {"middlewares": {"auth": {"basicAuthh": {}}}}}`, "http.middlewares.auth.basicAuthh: unknown field"},
+		{"middleware field", `{"http": {"middlewares": {"strip": {"stripPrefix": {"prefixes": [1]}}}}}`, "http.middlewares.strip.stripPrefix.prefixes[0]: expected a string but got a number"},
+		{"duration", `{"http": {"services": {"api": {"loadBalancer": {"healthCheck": {"interval": true}}}}}}`, "http.services.api.loadBalancer.healthCheck.interval: expected a duration but got a boolean"},
+		{"routers object", `{"tcp": {"routers": []}}`, "tcp.routers: expected an object but got a list"},
+		{"udp router", `{"udp": {"routers": {"dns": {"rule": "HostSNI(` + "`*`" + `)"}}}}`, "udp.routers.dns.rule: unknown field"},
+		{"tls certificate", `{"tls": {"certificates": [{"certFile": "a", "keyfile": 2}]}}`, "tls.certificates[0].keyfile: expected a string but got a number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDynamicConfig(mustUnmarshal(t, tt.config))
+			assert.EqualError(t, err, "invalid dynamic configuration: "+tt.expectedError)
+		})
+	}
+}
+
+func TestValidateDynamicConfigReportsEveryError(t *testing.T) {
+	err := validateDynamicConfig(mustUnmarshal(t, `{
+		"http": {
+			"routers": {
+				"b": {"service": 1},
+				"a": {"servce": "api"}
+			}
+		},
+		"tcp": {"services": {"db": {"loadbalancer": {"servers": "10.0.0.2"}}}}
+	}`))
+	assert.EqualError(t, err, "invalid dynamic configuration: "+
+		"http.routers.a.servce: unknown field; "+
+		"http.routers.b.service: expected a string but got a number; "+
+		"tcp.services.db.loadbalancer.servers: expected a list but got a string")
+}
+
+func TestValidateSchemaKeepsLastGoodConfig(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "validateSchema": true, "cacheDir": "`+t.TempDir()+`", "objects": [
+		{
+			"key": "dynamic.yaml",
+			"bucket": "someBucket"
+		}
+	]}`), &config)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	provider, err := New(ctx, &config, "test")
+	require.NoError(t, err)
+	s3Client := newMockS3Client()
+	provider.retrievers[0].client = s3Client
+	now := time.Now()
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("valid"),
+		Body:         io.NopCloser(bytes.NewReader([]byte(validDynamicConfig))),
+	}, nil).Once()
+	s3Client.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("invalid"),
+		Body:         io.NopCloser(bytes.NewReader([]byte("http:\n  routres: {}\n"))),
+	}, nil).Once()
+
+	cfgChan := make(chan json.Marshaler, 2)
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	valid, err := (<-cfgChan).MarshalJSON()
+	require.NoError(t, err)
+
+	// The invalid configuration is reported instead of provided and is not cached
+	provider.provideConfiguration(ctx, cfgChan, nil)
+	_, err = (<-cfgChan).MarshalJSON()
+	assert.EqualError(t, err, "invalid dynamic configuration: http.routres: unknown field")
+	assert.JSONEq(t, string(valid), string(provider.loadCache()))
+}
+
+// The kind names printed by schemacheck
+var schemaKindNames = map[schemaKind]string{
+	schemaAny:      "any",
+	schemaString:   "string",
+	schemaInt:      "int",
+	schemaBool:     "bool",
+	schemaDuration: "duration",
+	schemaObject:   "object",
+	schemaMap:      "map",
+	schemaList:     "list",
+}
+
+// Adds the kind of the value at path, and of everything it contains, to kinds the same way schemacheck does
+func (s *schema) addKinds(kinds map[string]string, path string) {
+	kinds[path] = schemaKindNames[s.kind]
+	switch s.kind {
+	case schemaObject:
+		for name, field := range s.fields {
+			field.addKinds(kinds, joinPath(path, name))
+		}
+	case schemaMap, schemaList:
+		s.elem.addKinds(kinds, path+"[]")
+	}
+}
+
+// Returns the path of the object, map or list that contains the value at path
+func parentPath(path string) string {
+	if strings.HasSuffix(path, "[]") {
+		return strings.TrimSuffix(path, "[]")
+	}
+	if idx := strings.LastIndex(path, "."); idx >= 0 {
+		return path[:idx]
+	}
+	return ""
+}
+
+// Whether the value at path is inside a value that may be anything
+func insideAny(kinds map[string]string, path string) bool {
+	for path != "" {
+		path = parentPath(path)
+		if kinds[path] == "any" {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedPaths(kinds map[string]string) []string {
+	paths := make([]string, 0, len(kinds))
+	for path := range kinds {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Compares the schema with traefik's dynamic configuration types.  Run by make check_schema, which sets TRAEFIK_SCHEMA
+// to the output of schemacheck
+func TestSchemaMatchesTraefik(t *testing.T) {
+	file := os.Getenv("TRAEFIK_SCHEMA")
+	if file == "" {
+		t.Skip("TRAEFIK_SCHEMA is not set, run make check_schema")
+	}
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	traefik := map[string]string{}
+	require.NoError(t, json.Unmarshal(raw, &traefik))
+
+	ours := map[string]string{}
+	dynamicConfigSchema.addKinds(ours, "")
+
+	// Only the outermost difference is reported, not everything inside it
+	parentDiffers := func(path string) bool {
+		return path != "" && ours[parentPath(path)] != traefik[parentPath(path)]
+	}
+	for _, path := range sortedPaths(traefik) {
+		if parentDiffers(path) || insideAny(ours, path) {
+			continue
+		}
+		kind, ok := ours[path]
+		if !ok {
+			t.Errorf("%s: missing from the schema", path)
+		} else if kind != traefik[path] {
+			t.Errorf("%s: the schema expects %s but traefik has %s", path, kind, traefik[path])
+		}
+	}
+	for _, path := range sortedPaths(ours) {
+		if parentDiffers(path) {
+			continue
+		}
+		if _, ok := traefik[path]; !ok && !insideAny(traefik, path) {
+			t.Errorf("%s: not a traefik field", path)
+		}
+	}
+}
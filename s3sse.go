@@ -0,0 +1,137 @@
+package s3provider
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The only algorithm that s3 supports for customer provided keys
+const defaultSSECustomerAlgorithm = "AES256"
+
+// The length of an AES256 key
+const sseCustomerKeyLength = 32
+
+// The headers for a customer provided key
+type sseCustomerHeaders struct {
+	// The base64 encoded key
+	key string
+	// The base64 encoded MD5 digest of the key
+	keyMD5 string
+}
+
+// A customer provided encryption key (SSE-C) that is sent with every request for the objects it encrypts
+type SSECustomerKey struct {
+	algorithm string
+	// A file holding the key, re-read when modified. Empty for a static key
+	keyFile string
+
+	mu sync.Mutex
+	// The modification time of keyFile when headers was read
+	modTime time.Time
+	headers sseCustomerHeaders
+}
+
+// Creates a customer provided key from either a base64 encoded key or a file holding the raw or base64
+// encoded key. The algorithm defaults to AES256
+func NewSSECustomerKey(algorithm string, key string, keyFile string) (*SSECustomerKey, error) {
+	if key != "" && keyFile != "" {
+		return nil, errors.New("only one of sseCustomerKey or sseCustomerKeyFile can be set")
+	}
+	if key == "" && keyFile == "" {
+		return nil, errors.New("sseCustomerAlgorithm requires sseCustomerKey or sseCustomerKeyFile")
+	}
+	algorithm = strings.ToUpper(strings.TrimSpace(algorithm))
+	if algorithm == "" {
+		algorithm = defaultSSECustomerAlgorithm
+	}
+	if algorithm != defaultSSECustomerAlgorithm {
+		return nil, fmt.Errorf("%q is not a valid sse customer algorithm", algorithm)
+	}
+
+	sse := &SSECustomerKey{
+		algorithm: algorithm,
+		keyFile:   keyFile,
+	}
+	if keyFile != "" {
+		// Read now so that a missing or invalid file is found on startup
+		if _, err := sse.current(); err != nil {
+			return nil, err
+		}
+		return sse, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("sseCustomerKey is not base64 encoded: %w", err)
+	}
+	if sse.headers, err = newSSECustomerHeaders(raw); err != nil {
+		return nil, fmt.Errorf("invalid sseCustomerKey: %w", err)
+	}
+	return sse, nil
+}
+
+// Sets the customer key headers on the request
+func (sse *SSECustomerKey) apply(input *s3.GetObjectInput) error {
+	headers, err := sse.current()
+	if err != nil {
+		return err
+	}
+	input.SSECustomerAlgorithm = aws.String(sse.algorithm)
+	input.SSECustomerKey = aws.String(headers.key)
+	input.SSECustomerKeyMD5 = aws.String(headers.keyMD5)
+	return nil
+}
+
+// Returns the headers for the key, re-reading the key file if it was modified since it was last read
+func (sse *SSECustomerKey) current() (sseCustomerHeaders, error) {
+	sse.mu.Lock()
+	defer sse.mu.Unlock()
+	if sse.keyFile == "" {
+		return sse.headers, nil
+	}
+
+	info, err := os.Stat(sse.keyFile)
+	if err != nil {
+		return sseCustomerHeaders{}, fmt.Errorf("unable to stat sse customer key file %s: %w", sse.keyFile, err)
+	}
+	if sse.headers.key != "" && info.ModTime().Equal(sse.modTime) {
+		return sse.headers, nil
+	}
+	contents, err := os.ReadFile(sse.keyFile)
+	if err != nil {
+		return sseCustomerHeaders{}, fmt.Errorf("unable to read sse customer key file %s: %w", sse.keyFile, err)
+	}
+	raw := contents
+	if len(contents) != sseCustomerKeyLength {
+		// Not a raw key so it must be base64 encoded
+		if raw, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents))); err != nil {
+			return sseCustomerHeaders{}, fmt.Errorf("sse customer key file %s is neither a raw nor a base64 encoded key", sse.keyFile)
+		}
+	}
+	headers, err := newSSECustomerHeaders(raw)
+	if err != nil {
+		return sseCustomerHeaders{}, fmt.Errorf("invalid sse customer key file %s: %w", sse.keyFile, err)
+	}
+	sse.headers = headers
+	sse.modTime = info.ModTime()
+	return headers, nil
+}
+
+func newSSECustomerHeaders(raw []byte) (sseCustomerHeaders, error) {
+	if len(raw) != sseCustomerKeyLength {
+		return sseCustomerHeaders{}, fmt.Errorf("key must be %d bytes, not %d", sseCustomerKeyLength, len(raw))
+	}
+	digest := md5.Sum(raw)
+	return sseCustomerHeaders{
+		key:    base64.StdEncoding.EncodeToString(raw),
+		keyMD5: base64.StdEncoding.EncodeToString(digest[:]),
+	}, nil
+}
@@ -0,0 +1,201 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testSSEKey      = bytes.Repeat([]byte{0x42}, 32)
+	testSSEKey64    = base64.StdEncoding.EncodeToString(testSSEKey)
+	testSSEKeyMD5   = md5.Sum(testSSEKey)
+	testSSEKeyMD564 = base64.StdEncoding.EncodeToString(testSSEKeyMD5[:])
+)
+
+func TestNewSSECustomerKeyValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		algorithm     string
+		key           string
+		keyFile       string
+		expectedError string
+	}{
+		{"both", "", testSSEKey64, "key.bin", "only one of sseCustomerKey or sseCustomerKeyFile can be set"},
+		{"neither", "AES256", "", "", "sseCustomerAlgorithm requires sseCustomerKey or sseCustomerKeyFile"},
+		{"algorithm", "aws:kms", testSSEKey64, "", `"AWS:KMS" is not a valid sse customer algorithm`},
+		{"not base64", "", "not a key!", "", "sseCustomerKey is not base64 encoded"},
+		{"short", "", base64.StdEncoding.EncodeToString([]byte("short")), "", "invalid sseCustomerKey: key must be 32 bytes, not 5"},
+		{"missing file", "", "", filepath.Join(t.TempDir(), "missing"), "unable to stat sse customer key file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sse, err := NewSSECustomerKey(tt.algorithm, tt.key, tt.keyFile)
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, sse)
+		})
+	}
+}
+
+func TestSSECustomerKeyApply(t *testing.T) {
+	rawFile := filepath.Join(t.TempDir(), "raw.key")
+	require.NoError(t, os.WriteFile(rawFile, testSSEKey, 0600))
+	encodedFile := filepath.Join(t.TempDir(), "encoded.key")
+	require.NoError(t, os.WriteFile(encodedFile, []byte(testSSEKey64+"\n"), 0600))
+
+	var tests = []struct {
+		name    string
+		key     string
+		keyFile string
+	}{
+		{"static", testSSEKey64, ""},
+		{"raw file", "", rawFile},
+		{"base64 file", "", encodedFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sse, err := NewSSECustomerKey("aes256", tt.key, tt.keyFile)
+			require.NoError(t, err)
+
+			input := &s3.GetObjectInput{}
+			require.NoError(t, sse.apply(input))
+			assert.Equal(t, "AES256", aws.ToString(input.SSECustomerAlgorithm))
+			assert.Equal(t, testSSEKey64, aws.ToString(input.SSECustomerKey))
+			assert.Equal(t, testSSEKeyMD564, aws.ToString(input.SSECustomerKeyMD5))
+		})
+	}
+}
+
+func TestSSECustomerKeyFileRotation(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "sse.key")
+	require.NoError(t, os.WriteFile(keyFile, testSSEKey, 0600))
+	sse, err := NewSSECustomerKey("", "", keyFile)
+	require.NoError(t, err)
+
+	rotated := bytes.Repeat([]byte{0x24}, 32)
+	require.NoError(t, os.WriteFile(keyFile, rotated, 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+
+	input := &s3.GetObjectInput{}
+	require.NoError(t, sse.apply(input))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(rotated), aws.ToString(input.SSECustomerKey))
+
+	// A bad rotation is reported instead of sending the old key
+	require.NoError(t, os.WriteFile(keyFile, []byte("oops"), 0600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.ErrorContains(t, sse.apply(&s3.GetObjectInput{}), "invalid sse customer key file "+keyFile+": key must be 32 bytes, not 3")
+}
+
+func TestRetrieveSSECustomerKey(t *testing.T) {
+	ctx := context.Background()
+	sse, err := NewSSECustomerKey("", testSSEKey64, "")
+	require.NoError(t, err)
+	mockClient := newMockS3Client()
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == testKey && aws.ToString(arg.SSECustomerAlgorithm) == "AES256" &&
+			aws.ToString(arg.SSECustomerKey) == testSSEKey64 && aws.ToString(arg.SSECustomerKeyMD5) == testSSEKeyMD564
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String(testETag),
+		Body: io.NopCloser(bytes.NewReader([]byte(testJson))),
+	}, nil)
+	// The version pointer is encrypted with the same key
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "testkey.version" && aws.ToString(arg.SSECustomerKey) == testSSEKey64 &&
+			aws.ToString(arg.SSECustomerKeyMD5) == testSSEKeyMD564
+	}), mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String("pointer"),
+		Body: io.NopCloser(bytes.NewReader([]byte("latest"))),
+	}, nil)
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket:         testBucket,
+		Key:            testKey,
+		Parser:         Json,
+		VersionPointer: "testkey.version",
+		SSECustomerKey: sse,
+	})
+
+	changed, err := retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, testJsonMap, retriever.data.json)
+	mockClient.AssertExpectations(t)
+}
+
+func TestManifestSSECustomerKey(t *testing.T) {
+	ctx := context.Background()
+	sse, err := NewSSECustomerKey("", testSSEKey64, "")
+	require.NoError(t, err)
+	mockClient := newMockS3Client()
+	source := NewS3ManifestSource(mockClient, ManifestConfig{
+		Bucket:         testBucket,
+		Key:            "release.json",
+		SSECustomerKey: sse,
+	})
+
+	withKey := func(key string) interface{} {
+		return mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+			return *arg.Key == key && aws.ToString(arg.SSECustomerAlgorithm) == "AES256" &&
+				aws.ToString(arg.SSECustomerKey) == testSSEKey64 && aws.ToString(arg.SSECustomerKeyMD5) == testSSEKeyMD564
+		})
+	}
+	mockClient.On("GetObject", ctx, withKey("release.json"), mock.Anything).Return(objectOutput("m1",
+		`{"objects": [{"key": "routers.json", "etag": "r1"}]}`), nil).Once()
+	mockClient.On("GetObject", ctx, withKey("routers.json"), mock.Anything).Return(objectOutput(`"r1"`, testJson), nil).Once()
+
+	retrievers, changed, err := source.Retrievers(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	require.Len(t, retrievers, 1)
+	assert.Equal(t, testJsonMap, retrievers[0].data.json)
+	mockClient.AssertExpectations(t)
+}
+
+func TestNewSSECustomerKeySettings(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [
+		{
+			"key": "tls.json",
+			"bucket": "someBucket",
+			"sseCustomerKey": "`+testSSEKey64+`"
+		},
+		{
+			"prefix": "tls/",
+			"bucket": "someBucket",
+			"sseCustomerKey": "`+testSSEKey64+`"
+		},
+		{
+			"key": "plain.json",
+			"bucket": "someBucket"
+		}
+	]}`), &config)
+	require.NoError(t, err)
+
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	require.NotNil(t, provider.retrievers[0].SSECustomerKey)
+	assert.Equal(t, "AES256", provider.retrievers[0].SSECustomerKey.algorithm)
+	assert.NotNil(t, provider.sources[1].(*S3PrefixDiscoverer).SSECustomerKey)
+	assert.Nil(t, provider.retrievers[1].SSECustomerKey)
+
+	config.Objects[2].SSECustomerAlgorithm = "AES256"
+	provider, err = New(context.Background(), &config, "test")
+	assert.EqualError(t, err, "object[2] sseCustomerAlgorithm requires sseCustomerKey or sseCustomerKeyFile")
+	assert.Nil(t, provider)
+}
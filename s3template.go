@@ -0,0 +1,118 @@
+package s3provider
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// How an object is rendered before it is parsed
+type TemplateMode string
+
+const (
+	// The object is parsed as is. The default
+	TemplateNone TemplateMode = "none"
+	// ${VAR} is replaced with the variable's value. $${ is an escaped ${
+	TemplateEnv TemplateMode = "env"
+	// The object is a Go text/template with the variables as its data, i.e. {{ .VAR }}
+	TemplateGo TemplateMode = "gotemplate"
+)
+
+// Parses a template mode name. An empty name is the default none mode
+func ParseTemplateMode(s string) (TemplateMode, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	switch TemplateMode(s) {
+	case "", TemplateNone:
+		return TemplateNone, nil
+	case TemplateEnv, TemplateGo:
+		return TemplateMode(s), nil
+	default:
+		return "", fmt.Errorf("%q is not a valid template mode", s)
+	}
+}
+
+// Matches an escaped "$${" or a "${VAR}" reference
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Renders objects with variables from the environment and the configuration
+type Templater struct {
+	mode TemplateMode
+	// Configured variables. These take precedence over the environment
+	variables map[string]string
+}
+
+// Creates a templater for the mode or nil if the mode is TemplateNone
+func NewTemplater(mode TemplateMode, variables map[string]string) *Templater {
+	if mode == TemplateNone || mode == "" {
+		return nil
+	}
+	return &Templater{
+		mode:      mode,
+		variables: variables,
+	}
+}
+
+func (templater *Templater) lookup(name string) (string, bool) {
+	if value, ok := templater.variables[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// Renders the raw object. Referencing a variable that is neither configured nor in the environment is an error
+func (templater *Templater) Render(raw []byte) ([]byte, error) {
+	switch templater.mode {
+	case TemplateEnv:
+		return templater.expand(raw)
+	case TemplateGo:
+		return templater.execute(raw)
+	default:
+		return raw, nil
+	}
+}
+
+func (templater *Templater) expand(raw []byte) ([]byte, error) {
+	var unknown []string
+	expanded := envReference.ReplaceAllFunc(raw, func(match []byte) []byte {
+		if string(match) == "$${" {
+			return []byte("${")
+		}
+		name := string(match[2 : len(match)-1])
+		value, ok := templater.lookup(name)
+		if !ok {
+			unknown = append(unknown, name)
+			return match
+		}
+		return []byte(value)
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown variables: %s", strings.Join(unknown, ", "))
+	}
+	return expanded, nil
+}
+
+func (templater *Templater) execute(raw []byte) ([]byte, error) {
+	tmpl, err := template.New("object").Option("missingkey=error").Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			data[name] = value
+		}
+	}
+	for name, value := range templater.variables {
+		data[name] = value
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+	return rendered.Bytes(), nil
+}
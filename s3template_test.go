@@ -0,0 +1,163 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplateMode(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected TemplateMode
+	}{
+		{"", TemplateNone},
+		{"none", TemplateNone},
+		{"Env", TemplateEnv},
+		{" gotemplate ", TemplateGo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, err := ParseTemplateMode(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, mode)
+		})
+	}
+
+	_, err := ParseTemplateMode("jinja")
+	assert.ErrorContains(t, err, `"jinja" is not a valid template mode`)
+	assert.Nil(t, NewTemplater(TemplateNone, nil))
+}
+
+func TestTemplaterRender(t *testing.T) {
+	t.Setenv("S3PROVIDER_TEST_HOST", "env.example.com")
+	t.Setenv("S3PROVIDER_TEST_ENV", "staging")
+	variables := map[string]string{
+		"S3PROVIDER_TEST_HOST": "config.example.com",
+	}
+
+	var tests = []struct {
+		name     string
+		mode     TemplateMode
+		raw      string
+		expected string
+	}{
+		{"env variables", TemplateEnv, "rule: Host(`${S3PROVIDER_TEST_HOST}`) # ${S3PROVIDER_TEST_ENV}", "rule: Host(`config.example.com`) # staging"},
+		{"env leaves other dollars alone", TemplateEnv, "users: [test:$apr1$H6uskkkW$IgXLP6]\nreplacement: $1/$S3PROVIDER_TEST_ENV", "users: [test:$apr1$H6uskkkW$IgXLP6]\nreplacement: $1/$S3PROVIDER_TEST_ENV"},
+		{"env escape", TemplateEnv, "value: $${S3PROVIDER_TEST_ENV}", "value: ${S3PROVIDER_TEST_ENV}"},
+		{"go template variables", TemplateGo, "rule: Host(`{{ .S3PROVIDER_TEST_HOST }}`) # {{ .S3PROVIDER_TEST_ENV }}", "rule: Host(`config.example.com`) # staging"},
+		{"go template functions", TemplateGo, `{{ if eq .S3PROVIDER_TEST_ENV "staging" }}debug: true{{ end }}`, "debug: true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := NewTemplater(tt.mode, variables).Render([]byte(tt.raw))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(rendered))
+		})
+	}
+}
+
+func TestTemplaterRenderUnknownVariables(t *testing.T) {
+	_, err := NewTemplater(TemplateEnv, nil).Render([]byte("a: ${S3PROVIDER_TEST_MISSING}\nb: ${S3PROVIDER_TEST_MISSING2}"))
+	assert.EqualError(t, err, "unknown variables: S3PROVIDER_TEST_MISSING, S3PROVIDER_TEST_MISSING2")
+
+	_, err = NewTemplater(TemplateGo, nil).Render([]byte("a: {{ .S3PROVIDER_TEST_MISSING }}"))
+	assert.ErrorContains(t, err, `map has no entry for key "S3PROVIDER_TEST_MISSING"`)
+
+	_, err = NewTemplater(TemplateGo, nil).Render([]byte("a: {{ .Unterminated "))
+	assert.Error(t, err)
+}
+
+func TestRetrieveTemplate(t *testing.T) {
+	now := time.Now()
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String(testETag),
+		Body:         io.NopCloser(bytes.NewReader([]byte("thing: ${THING}\nvalue: ${VALUE}"))),
+	}, nil).Once()
+	mockClient.On("GetObject", ctx, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		LastModified: &now,
+		ETag:         aws.String("\"other\""),
+		Body:         io.NopCloser(bytes.NewReader([]byte("thing: ${MISSING}"))),
+	}, nil).Once()
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket:   testBucket,
+		Key:      testKey,
+		Parser:   Yaml,
+		Template: NewTemplater(TemplateEnv, map[string]string{"THING": "true", "VALUE": "12"}),
+	})
+
+	// Rendered values are parsed with their yaml types
+	changed, err := retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]interface{}{"thing": true, "value": float64(12)}, retriever.data.json)
+
+	changed, err = retriever.Retrieve(ctx)
+	assert.EqualError(t, err, "failed to render template for testbucket/testkey: unknown variables: MISSING")
+	assert.False(t, changed)
+	assert.Equal(t, testETag, retriever.data.etag, "the previous data is kept")
+}
+
+func TestNewTemplateValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		config        string
+		expectedError string
+	}{
+		{"global", `"template": "jinja", "objects": [{"key": "huh.json", "bucket": "someBucket"}]`, `"jinja" is not a valid template mode`},
+		{"object", `"objects": [{"key": "huh.json", "bucket": "someBucket", "template": "jinja"}]`, `object[0] "jinja" is not a valid template mode`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", `+tt.config+`}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+}
+
+func TestNewTemplateSettings(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "template": "env", "variables": {"HOST": "a.com"}, "objects": [
+		{
+			"key": "huh.json",
+			"bucket": "someBucket"
+		},
+		{
+			"key": "raw.json",
+			"bucket": "someBucket",
+			"template": "none"
+		},
+		{
+			"key": "go.json",
+			"bucket": "someBucket",
+			"template": "gotemplate"
+		}
+	]}`), &config)
+	require.NoError(t, err)
+
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	assert.Equal(t, &Templater{mode: TemplateEnv, variables: map[string]string{"HOST": "a.com"}}, provider.retrievers[0].Template)
+	assert.Nil(t, provider.retrievers[1].Template)
+	assert.Equal(t, TemplateGo, provider.retrievers[2].Template.mode)
+}
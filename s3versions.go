@@ -0,0 +1,96 @@
+package s3provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// A version that means the object is not pinned
+const latestVersion = "latest"
+
+// The largest pointer object that is read. Version ids are far shorter
+const maxVersionPointerSize = 1024
+
+// The version named by a pointer object the last time it was read
+type versionPointerData struct {
+	// The ETag of the pointer object
+	etag string
+	// The version id in the pointer object. Empty for the latest version
+	versionID string
+}
+
+// Returns the version of the object to retrieve, reading the version pointer object if there is one.
+// An empty version is the latest version
+func (retriever *S3ObjectRetriever) resolveVersion(ctx context.Context) (string, error) {
+	if retriever.VersionPointer == "" {
+		if retriever.VersionID == latestVersion {
+			return "", nil
+		}
+		return retriever.VersionID, nil
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(retriever.Bucket),
+		Key:    aws.String(retriever.VersionPointer),
+	}
+	if retriever.pointer != nil {
+		input.IfNoneMatch = aws.String(retriever.pointer.etag)
+	}
+	// The pointer is stored alongside the object so it is encrypted with the same key
+	if retriever.SSECustomerKey != nil {
+		if err := retriever.SSECustomerKey.apply(input); err != nil {
+			return "", err
+		}
+	}
+	output, err := retriever.client.GetObject(ctx, input)
+	if err != nil {
+		if isNotModified(err) {
+			return retriever.pointer.versionID, nil
+		}
+		return "", fmt.Errorf("failed to get version pointer %s/%s: %w", retriever.Bucket, retriever.VersionPointer, err)
+	}
+	defer output.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(output.Body, maxVersionPointerSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read version pointer %s/%s: %w", retriever.Bucket, retriever.VersionPointer, err)
+	}
+	versionID, err := parseVersionPointer(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid version pointer %s/%s: %w", retriever.Bucket, retriever.VersionPointer, err)
+	}
+
+	if retriever.pointer == nil || retriever.pointer.versionID != versionID {
+		log.Printf("version pointer %s/%s now selects version %q of %s", retriever.Bucket, retriever.VersionPointer, versionID, retriever.Key)
+	}
+	retriever.pointer = &versionPointerData{
+		etag:      aws.ToString(output.ETag),
+		versionID: versionID,
+	}
+	return versionID, nil
+}
+
+// Reads the version id from the contents of a pointer object. "latest" selects the latest version
+func parseVersionPointer(raw []byte) (string, error) {
+	if len(raw) > maxVersionPointerSize {
+		return "", fmt.Errorf("larger than %d bytes", maxVersionPointerSize)
+	}
+	versionID := strings.TrimSpace(string(raw))
+	if versionID == "" {
+		return "", errors.New("no version id")
+	}
+	if strings.ContainsAny(versionID, " \t\r\n") {
+		return "", errors.New("more than one version id")
+	}
+	if versionID == latestVersion {
+		return "", nil
+	}
+	return versionID, nil
+}
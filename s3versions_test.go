@@ -0,0 +1,192 @@
+package s3provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersionPointer(t *testing.T) {
+	var tests = []struct {
+		name        string
+		raw         string
+		expected    string
+		expectedErr string
+	}{
+		{"version id", "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY\n", "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY", ""},
+		{"latest", " latest ", "", ""},
+		{"empty", "\n", "", "no version id"},
+		{"several", "v1\nv2", "", "more than one version id"},
+		{"too large", string(make([]byte, maxVersionPointerSize+1)), "", "larger than 1024 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versionID, err := parseVersionPointer([]byte(tt.raw))
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, versionID)
+		})
+	}
+}
+
+func matchGet(key string, versionID string) interface{} {
+	return mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == key && aws.ToString(arg.VersionId) == versionID
+	})
+}
+
+func TestRetrievePinnedVersion(t *testing.T) {
+	var tests = []struct {
+		versionID string
+		expected  string
+	}{
+		{"v1", "v1"},
+		{"latest", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.versionID, func(t *testing.T) {
+			ctx := context.Background()
+			mockClient := newMockS3Client()
+			mockClient.On("GetObject", ctx, matchGet(testKey, tt.expected), mock.Anything).Return(&s3.GetObjectOutput{
+				ETag: aws.String(testETag),
+				Body: io.NopCloser(bytes.NewReader([]byte(testJson))),
+			}, nil)
+			retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+				Bucket:    testBucket,
+				Key:       testKey,
+				Parser:    Json,
+				VersionID: tt.versionID,
+			})
+
+			changed, err := retriever.Retrieve(ctx)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRetrieveVersionPointer(t *testing.T) {
+	ctx := context.Background()
+	mockClient := newMockS3Client()
+	retriever := NewS3ObjectRetriever(mockClient, RetrieverConfig{
+		Bucket:         testBucket,
+		Key:            testKey,
+		Parser:         Json,
+		VersionPointer: "testkey.version",
+	})
+	matchPointer := mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "testkey.version"
+	})
+
+	// The pointer selects v1
+	mockClient.On("GetObject", ctx, matchPointer, mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String("pointer1"),
+		Body: io.NopCloser(bytes.NewReader([]byte("v1\n"))),
+	}, nil).Once()
+	mockClient.On("GetObject", ctx, matchGet(testKey, "v1"), mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String("etag1"),
+		Body: io.NopCloser(bytes.NewReader([]byte(`{"value": 1}`))),
+	}, nil).Once()
+	changed, err := retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]interface{}{"value": float64(1)}, retriever.data.json)
+
+	// The pointer is unchanged so v1 is requested again, conditionally
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == "testkey.version" && aws.ToString(arg.IfNoneMatch) == "pointer1"
+	}), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	mockClient.On("GetObject", ctx, mock.MatchedBy(func(arg *s3.GetObjectInput) bool {
+		return *arg.Key == testKey && aws.ToString(arg.VersionId) == "v1" && aws.ToString(arg.IfNoneMatch) == "etag1"
+	}), mock.Anything).Return(nil, newNotModifiedError()).Once()
+	changed, err = retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// The pointer is promoted to v2
+	mockClient.On("GetObject", ctx, matchPointer, mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String("pointer2"),
+		Body: io.NopCloser(bytes.NewReader([]byte("v2"))),
+	}, nil).Once()
+	mockClient.On("GetObject", ctx, matchGet(testKey, "v2"), mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String("etag2"),
+		Body: io.NopCloser(bytes.NewReader([]byte(`{"value": 2}`))),
+	}, nil).Once()
+	changed, err = retriever.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]interface{}{"value": float64(2)}, retriever.data.json)
+
+	// A broken pointer keeps the data from the last good version
+	mockClient.On("GetObject", ctx, matchPointer, mock.Anything).Return(&s3.GetObjectOutput{
+		ETag: aws.String("pointer3"),
+		Body: io.NopCloser(bytes.NewReader([]byte(""))),
+	}, nil).Once()
+	changed, err = retriever.Retrieve(ctx)
+	assert.EqualError(t, err, "invalid version pointer testbucket/testkey.version: no version id")
+	assert.False(t, changed)
+	assert.Equal(t, "etag2", retriever.data.etag)
+
+	mockClient.On("GetObject", ctx, matchPointer, mock.Anything).Return(nil, errors.New("Oh no!")).Once()
+	_, err = retriever.Retrieve(ctx)
+	assert.EqualError(t, err, "failed to get version pointer testbucket/testkey.version: Oh no!")
+	mockClient.AssertExpectations(t)
+}
+
+func TestRetrieverAffectedByVersionPointer(t *testing.T) {
+	retriever := NewS3ObjectRetriever(nil, RetrieverConfig{
+		Bucket:         testBucket,
+		Key:            testKey,
+		VersionPointer: "testkey.version",
+	})
+
+	assert.True(t, retriever.affectedBy([]S3ObjectEvent{{Bucket: testBucket, Key: testKey}}))
+	assert.True(t, retriever.affectedBy([]S3ObjectEvent{{Bucket: testBucket, Key: "testkey.version"}}))
+	assert.False(t, retriever.affectedBy([]S3ObjectEvent{{Bucket: "other", Key: "testkey.version"}}))
+}
+
+func TestNewVersionValidation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		object        string
+		expectedError string
+	}{
+		{"both", `"key": "huh.json", "versionId": "v1", "versionPointer": "huh.version"`, "object[0] cannot have both a versionId and a versionPointer"},
+		{"prefix", `"prefix": "dynamic/", "versionId": "v1"`, "object[0] cannot pin a version with a prefix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [{"bucket": "someBucket", `+tt.object+`}]}`), &config)
+			require.NoError(t, err)
+
+			provider, err := New(context.Background(), &config, "test")
+			assert.ErrorContains(t, err, tt.expectedError)
+			assert.Nil(t, provider)
+		})
+	}
+
+	var config Config
+	err := json.Unmarshal([]byte(`{"pollInterval": "5m", "objects": [{"bucket": "someBucket", "key": "huh.json", "versionPointer": "huh.version"}]}`), &config)
+	require.NoError(t, err)
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+	assert.Equal(t, "huh.version", provider.retrievers[0].VersionPointer)
+}
@@ -0,0 +1,5 @@
+module github.com/hanseltime/s3provider/schemacheck
+
+go 1.22
+
+require github.com/traefik/traefik/v3 v3.4.0
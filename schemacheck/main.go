@@ -0,0 +1,117 @@
+// Prints the fields of traefik's dynamic configuration as a json object of each field's path and kind, so that the
+// plugin's hand written schema can be compared with it without the plugin depending on traefik.  See the check_schema
+// target of the Makefile
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/traefik/traefik/v3/pkg/config/dynamic"
+)
+
+// Deeper than any traefik configuration, so reaching it means a type refers to itself
+const maxDepth = 20
+
+func main() {
+	kinds := map[string]string{}
+	if err := addFields(kinds, "", reflect.TypeOf(dynamic.Configuration{}), 0); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(kinds); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Adds the kind of the value at path, and of everything it contains, to kinds. Map and list elements are at <path>[]
+func addFields(kinds map[string]string, path string, typ reflect.Type, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%s: nested too deeply", path)
+	}
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch {
+	// Durations are parsed from a string or a number of seconds
+	case typ.Name() == "Duration":
+		kinds[path] = "duration"
+	case typ.Kind() == reflect.Struct:
+		kinds[path] = "object"
+		fields, err := structFields(typ)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name, fieldType := range fields {
+			if err := addFields(kinds, joinPath(path, name), fieldType, depth+1); err != nil {
+				return err
+			}
+		}
+	case typ.Kind() == reflect.Map:
+		kinds[path] = "map"
+		return addFields(kinds, path+"[]", typ.Elem(), depth+1)
+	case typ.Kind() == reflect.Slice:
+		kinds[path] = "list"
+		return addFields(kinds, path+"[]", typ.Elem(), depth+1)
+	case typ.Kind() == reflect.String:
+		kinds[path] = "string"
+	case typ.Kind() == reflect.Bool:
+		kinds[path] = "bool"
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		kinds[path] = "int"
+	case typ.Kind() == reflect.Interface:
+		kinds[path] = "any"
+	default:
+		return fmt.Errorf("%s: unsupported type %s", path, typ)
+	}
+	return nil
+}
+
+// Returns the types of a struct's fields by their json name, including the fields of inlined structs
+func structFields(typ reflect.Type) (map[string]reflect.Type, error) {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			embeddedFields, err := structFields(embedded)
+			if err != nil {
+				return nil, err
+			}
+			for name, fieldType := range embeddedFields {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			return nil, fmt.Errorf("field %s has no json name", field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields, nil
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
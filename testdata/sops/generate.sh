@@ -0,0 +1,16 @@
+#!/bin/sh
+# Encrypts the plaintext fixtures with the sops cli (3.9 or later, for --mac-only-encrypted) and the test key.
+# Run from the repository root with `make sops_fixtures`
+set -eu
+cd "$(dirname "$0")"
+
+recipient=$(sed -n 's/^# public key: //p' key.txt)
+
+sops --encrypt --age "$recipient" --input-type yaml --output-type yaml config.yaml > config.enc.yaml
+sops --encrypt --age "$recipient" --input-type json --output-type json config.json > config.enc.json
+sops --encrypt --age "$recipient" --mac-only-encrypted --input-type yaml --output-type yaml config.yaml > config.mac-only.enc.yaml
+
+# Check that sops itself can read them back
+for fixture in config.enc.yaml config.enc.json config.mac-only.enc.yaml; do
+	SOPS_AGE_KEY_FILE=key.txt sops --decrypt "$fixture" > /dev/null
+done
@@ -2,9 +2,18 @@ package s3provider
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type mockS3Client struct {
@@ -23,11 +32,25 @@ func (m *mockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput,
 	if resp == nil {
 		return nil, args.Error(1)
 	}
+	// Builds the output per call, i.e. so that concurrent calls do not share a body
+	if newOutput, ok := resp.(func(*s3.GetObjectInput) *s3.GetObjectOutput); ok {
+		return newOutput(params), args.Error(1)
+	}
 
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
-func (m *mockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+// The error the s3 client returns when a conditional GET matches the current ETag
+func newNotModifiedError() error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{
+			Response: &http.Response{StatusCode: http.StatusNotModified},
+		},
+		Err: errors.New("not modified"),
+	}
+}
+
+func (m *mockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
 	args := m.Called(ctx, params, optFns)
 
 	resp := args.Get(0)
@@ -36,5 +59,53 @@ func (m *mockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInpu
 		return nil, args.Error(1)
 	}
 
-	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
-}
\ No newline at end of file
+	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
+}
+
+// Creates a listing page with an object for each key
+func newListPage(keys ...string) *s3.ListObjectsV2Output {
+	contents := make([]types.Object, len(keys))
+	for i, key := range keys {
+		contents[i] = types.Object{Key: aws.String(key)}
+	}
+	return &s3.ListObjectsV2Output{
+		Contents: contents,
+	}
+}
+
+// Creates a provider for the objects (a json object each) with every object, prefix and manifest retrieved
+// through the returned mock client. settings are more top level config fields, which can override the 1s
+// pollInterval
+func newTestProvider(t *testing.T, settings string, objects ...string) (*Provider, *mockS3Client) {
+	t.Helper()
+	raw := `{"pollInterval": "1s", `
+	if settings != "" {
+		raw += settings + ", "
+	}
+	raw += `"objects": [` + strings.Join(objects, ", ") + `]}`
+	var config Config
+	require.NoError(t, json.Unmarshal([]byte(raw), &config))
+
+	provider, err := New(context.Background(), &config, "test")
+	require.NoError(t, err)
+
+	s3Client := newMockS3Client()
+	for _, source := range provider.sources {
+		switch source := source.(type) {
+		case staticSource:
+			source.retriever.client = s3Client
+		case *S3PrefixDiscoverer:
+			source.client = s3Client
+		case *S3ManifestSource:
+			source.client = s3Client
+		}
+	}
+	return provider, s3Client
+}
+
+func mustUnmarshal(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &m))
+	return m
+}